@@ -2,38 +2,39 @@ package dxvk
 
 import (
 	"github.com/sewnie/wine"
-	"slices"
 )
 
 var overridesRegPath = `HKEY_CURRENT_USER\Software\Wine\DllOverrides`
 
+// overrides are the DLL overrides used by DXVK.
+type overrides struct {
+	D3D10Core string `reg:"d3d10core,sz"`
+	D3D11     string `reg:"d3d11,sz"`
+	D3D9      string `reg:"d3d9,sz"`
+	DXGI      string `reg:"dxgi,sz"`
+}
+
 // Overriden checks if the DXVK DLL overrides have been
 // installed in the Wineprefix.
+//
+// Wine is only run to query the registry if the Wineprefix is
+// running; see [wine.Prefix.RegistryBackend].
 func Overriden(pfx *wine.Prefix) (bool, error) {
-	k, err := pfx.RegistryQuery(overridesRegPath)
+	k, err := pfx.RegistryBackend().Query(overridesRegPath)
 	if err != nil {
 		return false, err
 	}
 
-	overrides := []wine.RegistryValue{
-		{"d3d10core", "builtin"},
-		{"d3d11", "builtin"},
-		{"d3d9", "builtin"},
-		{"dxgi", "builtin"},
-	}
-
-	if len(k.Values) == 0 {
+	if k == nil || len(k.Values) == 0 {
 		return false, nil
 	}
 
-	allOverrides := true
-	for _, o := range overrides {
-		if !slices.Contains(k.Values, o) {
-			allOverrides = false
-		}
+	var o overrides
+	if err := wine.UnmarshalRegistry(k, &o); err != nil {
+		return false, err
 	}
 
-	return allOverrides, nil
+	return o == overrides{"builtin", "builtin", "builtin", "builtin"}, nil
 }
 
 // AddOverrides adds the DXVK DLL overrides to the Wineprefix.
@@ -41,20 +42,33 @@ func Overriden(pfx *wine.Prefix) (bool, error) {
 // This can be used regardless if DXVK is installed in the
 // Wineprefix or not.
 func AddOverrides(pfx *wine.Prefix) error {
-	return pfx.RegistryImportKey(registryKey("native,builtin"))
+	o := "native,builtin"
+	k, err := registryKey(overrides{o, o, o, o})
+	if err != nil {
+		return err
+	}
+	return pfx.RegistryBackend().Import(k)
 }
 
 // AddOverrides removes the DXVK DLL overrides to the Wineprefix.
 func RemoveOverrides(pfx *wine.Prefix) error {
+	k, err := registryKey(overrides{})
+	if err != nil {
+		return err
+	}
 	// Delete the overrides
-	return pfx.RegistryImportKey(registryKey(nil))
+	for i := range k.Values {
+		k.Values[i].Data = wine.Deleted{}
+	}
+	return pfx.RegistryBackend().Import(k)
 }
 
-func registryKey(dllOverride any) *wine.RegistryKey {
+func registryKey(o overrides) (*wine.RegistryKey, error) {
+	m, err := wine.MarshalRegistry(o)
+	if err != nil {
+		return nil, err
+	}
 	k := wine.NewRegistryKey(overridesRegPath)
-	k.SetValue("d3d10core", dllOverride)
-	k.SetValue("d3d11", dllOverride)
-	k.SetValue("d3d9", dllOverride)
-	k.SetValue("dxgi", dllOverride)
-	return k
+	k.Values = m.Values
+	return k, nil
 }
@@ -1,11 +1,15 @@
 package wine
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 // does not seem to change with current user
@@ -18,106 +22,388 @@ const sid = `S-1-5-21-0-0-0-1000`
 // To export a registry to a Wineprefix, the Registry must have
 // come from [Registry()], which requires an initialized wineprefix,
 // and the Wineserver must be killed as to not conflict with
-// Wineserver's internal registry.
+// Wineserver's internal registry; see [Registry.SaveWith].
 //
 // To write a RegistryKey to a Wineprefix, you can use either [Prefix.RegistryAdd]
-// or [Prefix.RegistryImportKey].
+// or [Prefix.RegistryImportKey], or [Prefix.RegistryBackend] to write to
+// the registry files directly when the Wineserver is not running.
 //
-// Only HKEY_CURRENT_USER and HKEY_LOCAL_MACHINE are supported
-// and queryable.
+// HKEY_LOCAL_MACHINE, HKEY_CURRENT_USER and HKEY_USERS\.Default are
+// backed by the Wineprefix's registry files. HKEY_CLASSES_ROOT,
+// HKEY_CURRENT_CONFIG and HKEY_USERS\<SID> are views onto subkeys
+// of Machine and CurrentUser, and are queryable as well.
 type Registry struct {
 	CurrentUser *RegistryKey
 	Machine     *RegistryKey
+	DefaultUser *RegistryKey
 
 	pfx *Prefix
 }
 
 // Registry parses and returns the registry for the given Wineprefix.
+// DefaultUser will be nil if the Wineprefix has no userdef.reg.
+//
+// The parsed registry files are cached until their modification time,
+// size or inode changes, in which case they will be parsed again. Each
+// call returns a copy of the cached registry, which may be modified.
+// Copying is still far cheaper than parsing, but copies every key and
+// value: for a freshly created Wineprefix, this is in the order of a few
+// milliseconds and megabytes per call, so callers that read the registry
+// repeatedly should keep the returned Registry rather than calling
+// Registry again.
 //
 // See the commment on [Registry] for more information.
 func (p *Prefix) Registry() (*Registry, error) {
 	r := Registry{pfx: p}
 
-	k, err := ParseRegistryFile(filepath.Join(p.dir, "system.reg"))
+	k, err := parseRegistryFile(filepath.Join(p.dir, "system.reg"))
 	if err != nil {
 		return nil, err
 	}
 	r.Machine = k
 
-	k, err = ParseRegistryFile(filepath.Join(p.dir, "user.reg"))
+	k, err = parseRegistryFile(filepath.Join(p.dir, "user.reg"))
 	if err != nil {
 		return nil, err
 	}
 	r.CurrentUser = k
 
+	k, err = parseRegistryFile(filepath.Join(p.dir, "userdef.reg"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	r.DefaultUser = k
+
 	return &r, nil
 }
 
+// registryFile is the last known state of a registry file.
+type registryFile struct {
+	name string
+	info os.FileInfo
+	key  *RegistryKey
+}
+
+// unchanged reports whether the file described by fi is the same as f
+// when it was last read.
+func (f *registryFile) unchanged(fi os.FileInfo) bool {
+	// The Wineserver replaces the registry files when saving them,
+	// which may happen within the same modification time.
+	return fi != nil && f.info != nil && os.SameFile(fi, f.info) &&
+		fi.ModTime().Equal(f.info.ModTime()) && fi.Size() == f.info.Size()
+}
+
+// registryCache holds the most recently parsed registry files by their
+// path, which are never modified and only handed out as copies.
+var registryCache = struct {
+	sync.Mutex
+	files map[string]*registryFile
+}{files: make(map[string]*registryFile)}
+
+// parseRegistryFile is like ParseRegistryFile, but returns a copy of the
+// registry file's root key from registryCache if it has not changed since
+// it was last parsed.
+func parseRegistryFile(name string) (*RegistryKey, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	registryCache.Lock()
+	f := registryCache.files[name]
+	registryCache.Unlock()
+	if f != nil && f.unchanged(fi) {
+		return f.key.clone(nil), nil
+	}
+
+	k, err := ParseRegistryFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	registryCache.Lock()
+	registryCache.files[name] = &registryFile{name, fi, k}
+	registryCache.Unlock()
+	return k.clone(nil), nil
+}
+
+// Arch returns the architecture of the Wineprefix, either "win32"
+// or "win64", as written in its registry files.
+func (r *Registry) Arch() string {
+	if r.Machine == nil {
+		return "win64"
+	}
+	return r.Machine.Arch()
+}
+
 // Query finds the given registry key path in r. nil will be
 // returned if no such key was found. The path must be prefixed
-// with only HKLM or HKCU (and their full counterparts), as they
-// are the only root keys available in Registry.
+// with a root key such as HKLM, HKCU, HKCR, HKU or HKCC (and their full
+// counterparts). Only the .Default and the current user's SID are
+// available under HKEY_USERS.
 func (r *Registry) Query(path string) *RegistryKey {
 	return r.queryPath(path, false)
 }
 
+// ModifiedSince returns the keys of the Wineprefix's registry files that
+// were modified after t. See [RegistryKey.ModifiedSince].
+func (r *Registry) ModifiedSince(t time.Time) []*RegistryKey {
+	var keys []*RegistryKey
+	for _, k := range []*RegistryKey{r.Machine, r.CurrentUser, r.DefaultUser} {
+		if k != nil {
+			keys = append(keys, k.ModifiedSince(t)...)
+		}
+	}
+	return keys
+}
+
+// QueryFollow is like [Registry.Query], but follows any symbolic link keys
+// encountered, including those linking to keys in other root keys.
+func (r *Registry) QueryFollow(path string) *RegistryKey {
+	k, key := r.root(path, false)
+	if k == nil {
+		return nil
+	}
+	return k.queryFollow(key, r.Query)
+}
+
 func (r *Registry) queryPath(path string, create bool) *RegistryKey {
-	i := strings.Index(path, `\`)
-	if i < 0 {
-		i = len(path)
+	k, key := r.root(path, create)
+	if k == nil {
+		return nil
 	}
+	return k.queryPath(key, create)
+}
+
+// root returns the root key that backs the given absolute path, and the
+// path relative to it.
+func (r *Registry) root(path string, create bool) (*RegistryKey, string) {
+	root, key, _ := strings.Cut(path, `\`)
 
 	// List of known registry names and their files (if applicable):
-	// - REGISTRY\User\.Default -> userdef.reg
-	// - HKEY_LOCAL_MACHINE -> REGISTRY\MACHINE -> system.reg
+	// - HKEY_LOCAL_MACHINE -> REGISTRY\Machine -> system.reg
 	// - HKEY_CURRENT_USER -> REGISTRY\User\S-1-5-21-0-0-0-1000 -> user.reg
-	// - HKEY_CLASSES_ROOT -> REGISTRY\MACHINE\Software\Classes
 	// - HKEY_USERS -> REGISTRY\User
-	// - HKEY_CURRENT_CONFIG -> REGISTRY\System\ControlSet001\Enum
-	switch root, key := path[:i], path[i+1:]; root {
+	// - HKEY_USERS\.Default -> REGISTRY\User\.Default -> userdef.reg
+	// - HKEY_CLASSES_ROOT -> REGISTRY\Machine\Software\Classes
+	// - HKEY_CURRENT_CONFIG -> REGISTRY\Machine\System\CurrentControlSet\Hardware Profiles\Current
+	var k **RegistryKey
+	var name string
+	switch strings.ToUpper(root) {
 	case "HKEY_LOCAL_MACHINE", "HKLM":
-		if r.Machine == nil {
-			r.Machine = &RegistryKey{Name: "HKEY_LOCAL_MACHINE"}
-		}
-		return r.Machine.queryPath(key, create)
+		k, name = &r.Machine, "HKEY_LOCAL_MACHINE"
 	case "HKEY_CURRENT_USER", "HKCU":
-		if r.CurrentUser == nil {
-			r.CurrentUser = &RegistryKey{Name: "HKEY_CURRENT_USER"}
+		k, name = &r.CurrentUser, "HKEY_CURRENT_USER"
+	case "HKEY_CLASSES_ROOT", "HKCR":
+		k, name = &r.Machine, "HKEY_LOCAL_MACHINE"
+		key = joinPath(`Software\Classes`, key)
+	case "HKEY_CURRENT_CONFIG", "HKCC":
+		// CurrentControlSet is a link to ControlSet001, which is
+		// not followed here.
+		k, name = &r.Machine, "HKEY_LOCAL_MACHINE"
+		key = joinPath(`System\ControlSet001\Hardware Profiles\Current`, key)
+	case "HKEY_USERS", "HKU":
+		var user string
+		user, key, _ = strings.Cut(key, `\`)
+		switch {
+		case equalName(user, ".Default"):
+			k, name = &r.DefaultUser, `HKEY_USERS\.Default`
+		case equalName(user, sid):
+			k, name = &r.CurrentUser, "HKEY_CURRENT_USER"
+		default:
+			return nil, ""
 		}
-		return r.CurrentUser.queryPath(key, create)
+	default:
+		return nil, ""
 	}
-	return nil
+
+	if *k == nil {
+		if !create {
+			return nil, ""
+		}
+		*k = &RegistryKey{Name: name}
+	}
+	return *k, key
+}
+
+func joinPath(parent, path string) string {
+	if path == "" {
+		return parent
+	}
+	if parent == "" {
+		return path
+	}
+	return parent + `\` + path
+}
+
+// ErrPrefixRunning is returned by [Registry.Save] if the Wineserver is
+// running, as it would overwrite the saved registry files with its
+// own internal registry.
+var ErrPrefixRunning = errors.New("wine: wineserver is running")
+
+// SaveOptions specifies how a [Registry] is written to the Wineprefix's
+// registry files.
+type SaveOptions struct {
+	// Backup keeps the previous registry files, with the .bak suffix
+	// appended to their names.
+	Backup bool
+
+	// Kill kills the Wineprefix with [Prefix.Kill] if it is running,
+	// instead of returning ErrPrefixRunning. Any changes the Wineserver
+	// flushes to the registry files when killed are overwritten.
+	Kill bool
+
+	// Canonical writes the registry files in the order and layout the
+	// Wineserver writes them, as done by the Canonical export option,
+	// to minimize the differences between them.
+	Canonical bool
 }
 
 // Save exports and writes r to the Wineprefix's registry files.
 // It is assumed that the Registry is serialized from the same
 // registry files and must exist.
 //
-// See the commment on [Registry] for what is exported.
+// See the commment on [Registry] for what is exported, and
+// [Registry.SaveWith] for how the registry files are written.
 func (r *Registry) Save() error {
+	return r.SaveWith(SaveOptions{})
+}
+
+// SaveWith is like [Registry.Save], but with the given options.
+//
+// The registry files are written to temporary files first, and only
+// replace the Wineprefix's registry files once all of them were exported
+// successfully. If the Wineserver is running, ErrPrefixRunning is
+// returned unless the Kill option was set.
+func (r *Registry) SaveWith(opts SaveOptions) error {
 	if r.pfx == nil {
 		return errors.New("wine: no registry origin")
 	}
-	s, err := os.OpenFile(filepath.Join(r.pfx.dir, "system.reg"),
-		os.O_TRUNC|os.O_WRONLY, 0o644)
-	if err != nil {
-		return fmt.Errorf("open machine: %w", err)
+	if r.pfx.Running() {
+		if !opts.Kill {
+			return ErrPrefixRunning
+		}
+		if err := r.pfx.Kill(); err != nil {
+			return fmt.Errorf("kill: %w", err)
+		}
+		if err := r.pfx.Server(ServerWait); err != nil {
+			return fmt.Errorf("wait: %w", err)
+		}
+		if r.pfx.Running() {
+			return ErrPrefixRunning
+		}
 	}
-	defer s.Close()
 
-	if err := r.Machine.exportSystem(s); err != nil {
-		return fmt.Errorf("export machine: %w", err)
+	files := []struct {
+		name string
+		key  *RegistryKey
+		tmp  string
+	}{
+		{name: "system.reg", key: r.Machine},
+		{name: "user.reg", key: r.CurrentUser},
+		{name: "userdef.reg", key: r.DefaultUser},
 	}
+	defer func() {
+		for _, f := range files {
+			if f.tmp != "" {
+				_ = os.Remove(f.tmp)
+			}
+		}
+	}()
 
-	u, err := os.OpenFile(filepath.Join(r.pfx.dir, "user.reg"),
-		os.O_TRUNC|os.O_WRONLY, 0o644)
-	if err != nil {
-		return fmt.Errorf("open user: %w", err)
+	for i, f := range files {
+		if f.key == nil {
+			continue
+		}
+		tmp, err := writeRegistryFile(filepath.Join(r.pfx.dir, f.name), f.key,
+			ExportOptions{Canonical: opts.Canonical})
+		if err != nil {
+			return fmt.Errorf("export %s: %w", f.name, err)
+		}
+		files[i].tmp = tmp
 	}
 
-	if err := r.CurrentUser.exportSystem(u); err != nil {
-		return fmt.Errorf("export user: %w", err)
+	for i, f := range files {
+		if f.tmp == "" {
+			continue
+		}
+		name := filepath.Join(r.pfx.dir, f.name)
+		if opts.Backup {
+			if err := backupFile(name); err != nil {
+				return fmt.Errorf("backup %s: %w", f.name, err)
+			}
+		}
+		if err := os.Rename(f.tmp, name); err != nil {
+			return fmt.Errorf("replace %s: %w", f.name, err)
+		}
+		files[i].tmp = ""
 	}
 
 	return nil
 }
+
+// writeRegistryFile exports k to a temporary file beside the named
+// registry file, and returns the temporary file's name.
+func writeRegistryFile(name string, k *RegistryKey, opts ExportOptions) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return "", err
+	}
+	err = func() error {
+		defer f.Close()
+
+		perm := os.FileMode(0o644)
+		if fi, err := os.Stat(name); err == nil {
+			perm = fi.Mode().Perm()
+		}
+		if err := f.Chmod(perm); err != nil {
+			return err
+		}
+
+		w := bufio.NewWriter(f)
+		if err := k.exportSystem(w, opts); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		return f.Close()
+	}()
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// backupFile links or copies the named file to the same name
+// with the .bak suffix. Missing files are ignored.
+func backupFile(name string) error {
+	bak := name + ".bak"
+	if err := os.Remove(bak); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	err := os.Link(name, bak)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(bak)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,148 @@
+package wine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// RegistryBackend reads and writes the registry of a Wineprefix, either
+// through its registry files or through Wine. Registry paths are absolute
+// and prefixed with a root key, as accepted by [Registry.Query].
+//
+// See [Prefix.RegistryBackend] to select the backend based on whether
+// the Wineserver is running.
+type RegistryBackend interface {
+	// Query returns the registry key at path, or nil if it does
+	// not exist.
+	Query(path string) (*RegistryKey, error)
+
+	// Set sets the named value of the registry key at path to data,
+	// creating the key if it does not exist. An empty name sets the
+	// (Default) value.
+	Set(path, name string, data RegistryData) error
+
+	// Delete deletes the named value of the registry key at path, or
+	// the key itself if name is empty.
+	Delete(path, name string) error
+
+	// Import writes k and its subkeys at k's absolute path, including
+	// the deletions it holds, as exported by [RegistryKey.Export].
+	Import(k *RegistryKey) error
+}
+
+// FileRegistry is a [RegistryBackend] that reads and writes the
+// Wineprefix's registry files directly with [Prefix.Registry] and
+// [Registry.Save], without running Wine. Each change is saved
+// immediately, and fails with ErrPrefixRunning if the Wineserver
+// is running.
+type FileRegistry struct {
+	Prefix *Prefix
+}
+
+// WineRegistry is a [RegistryBackend] that reads and writes the registry
+// of the running Wineserver, using [Prefix.RegistryQuery],
+// [Prefix.RegistryAdd], [Prefix.RegistryDelete] and
+// [Prefix.RegistryImportKey].
+type WineRegistry struct {
+	Prefix *Prefix
+}
+
+// RegistryBackend returns a [WineRegistry] for the Wineprefix if the
+// Wineserver is running, as the registry files do not have its changes
+// until it exits, or if the Wineprefix has no registry files yet, as
+// Wine creates them. Otherwise, a [FileRegistry] is returned, which does
+// not need to run Wine.
+func (p *Prefix) RegistryBackend() RegistryBackend {
+	if p.Running() {
+		return &WineRegistry{p}
+	}
+	for _, name := range []string{"system.reg", "user.reg"} {
+		if _, err := os.Stat(filepath.Join(p.dir, name)); err != nil {
+			return &WineRegistry{p}
+		}
+	}
+	return &FileRegistry{p}
+}
+
+func (f *FileRegistry) Query(path string) (*RegistryKey, error) {
+	r, err := f.Prefix.Registry()
+	if err != nil {
+		return nil, err
+	}
+	return r.Query(path), nil
+}
+
+func (f *FileRegistry) Set(path, name string, data RegistryData) error {
+	r, err := f.Prefix.Registry()
+	if err != nil {
+		return err
+	}
+	k := r.queryPath(path, true)
+	if k == nil {
+		return fmt.Errorf("wine: invalid registry key: %s", path)
+	}
+	k.SetValue(name, data)
+	return r.Save()
+}
+
+// Delete implements [RegistryBackend.Delete]. It is not an error if the
+// key or value does not exist.
+func (f *FileRegistry) Delete(path, name string) error {
+	r, err := f.Prefix.Registry()
+	if err != nil {
+		return err
+	}
+	k := r.Query(path)
+	switch {
+	case k == nil:
+		return nil
+	case name != "":
+		if !k.DeleteValue(name) {
+			return nil
+		}
+	case k.parent == nil:
+		return errors.New("wine: cannot delete root registry key")
+	default:
+		k.parent.Delete(k.Name)
+	}
+	return r.Save()
+}
+
+func (f *FileRegistry) Import(k *RegistryKey) error {
+	var buf bytes.Buffer
+	if err := k.Export(&buf); err != nil {
+		return err
+	}
+	var d RegistryDiff
+	if err := d.Import(&buf); err != nil {
+		return err
+	}
+
+	r, err := f.Prefix.Registry()
+	if err != nil {
+		return err
+	}
+	if _, err := r.Apply(d); err != nil {
+		return err
+	}
+	return r.Save()
+}
+
+func (w *WineRegistry) Query(path string) (*RegistryKey, error) {
+	return w.Prefix.RegistryQuery(path)
+}
+
+func (w *WineRegistry) Set(path, name string, data RegistryData) error {
+	return w.Prefix.RegistryAdd(path, name, data)
+}
+
+func (w *WineRegistry) Delete(path, name string) error {
+	return w.Prefix.RegistryDelete(path, name)
+}
+
+func (w *WineRegistry) Import(k *RegistryKey) error {
+	return w.Prefix.RegistryImportKey(k)
+}
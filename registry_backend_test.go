@@ -0,0 +1,74 @@
+package wine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistryBackend(t *testing.T) {
+	dir := t.TempDir()
+	pfx := New(dir, "")
+	backend := pfx.RegistryBackend()
+	if _, ok := backend.(*WineRegistry); !ok {
+		t.Fatalf("expected Wine registry backend for empty prefix, got %T", backend)
+	}
+
+	for name, data := range map[string]string{
+		"system.reg": registrySystemData,
+		"user.reg":   registryUserData,
+	} {
+		if err := os.WriteFile(filepath.Join(pfx.dir, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("unexpected %s write error: %v", name, err)
+		}
+	}
+
+	reg, ok := pfx.RegistryBackend().(*FileRegistry)
+	if !ok {
+		t.Fatalf("expected file registry backend, got %T", reg)
+	}
+
+	if err := reg.Set(`HKCU\Software\Foobar\Baz`, "Foo", DwordBE(1)); err != nil {
+		t.Fatalf("unexpected set error: %v", err)
+	}
+	if err := reg.Delete(`HKCU\Software\Foobar`, "Foo"); err != nil {
+		t.Fatalf("unexpected value delete error: %v", err)
+	}
+	if err := reg.Delete(`HKLM\Software\Foobar`, ""); err != nil {
+		t.Fatalf("unexpected key delete error: %v", err)
+	}
+	if err := reg.Delete(`HKLM\Software\Quux`, ""); err != nil {
+		t.Fatalf("unexpected missing key delete error: %v", err)
+	}
+
+	k := NewRegistryKey(`HKLM\Software\Quux`)
+	k.SetValue("Foo", "Bar")
+	k.Add("Baz").Deleted = true
+	if err := reg.Import(k); err != nil {
+		t.Fatalf("unexpected import error: %v", err)
+	}
+
+	k, err := reg.Query(`HKCU\Software\Foobar`)
+	if err != nil {
+		t.Fatalf("unexpected query error: %v", err)
+	}
+	if !clearModified(k).Equal(&RegistryKey{
+		Name:    "Foobar",
+		Values:  []RegistryValue{},
+		Subkeys: []*RegistryKey{{Name: "Baz", Values: []RegistryValue{{"Foo", DwordBE(1)}}}},
+	}) {
+		t.Fatalf("unexpected key %s", registryKeyJSON(k))
+	}
+
+	r, err := pfx.Registry()
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if k := r.Query(`HKLM\Software\Foobar`); k != nil {
+		t.Fatalf("expected key deletion, got %v", k)
+	}
+	if k := r.Query(`HKLM\Software\Quux`); k == nil || len(k.Subkeys) != 0 ||
+		k.GetValue("Foo") == nil {
+		t.Fatalf("expected imported key, got %v", k)
+	}
+}
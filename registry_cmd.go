@@ -1,11 +1,13 @@
 package wine
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -13,15 +15,24 @@ import (
 // RegistryAdd adds a new registry key to the Wineprefix with the named key, value,
 // type, and data. The value parameter can be empty, to modify the (Default) value.
 //
+// Data of types that cannot be given to reg, such as [DwordBE], [Link],
+// [BinaryString] and [InternalBytes], is added by importing a generated
+// registry file with regedit instead.
+//
 // See [RegistryData] for more details about the type of data.
 func (p *Prefix) RegistryAdd(key string, value string, data RegistryData) error {
 	if key == "" {
 		return errors.New("no registry key given")
 	}
+	if data == nil {
+		return errors.New("no registry data given")
+	}
 
 	t, d := formatRegistryData(data)
 	if t == "" {
-		return errors.New("unhandled type var")
+		k := NewRegistryKey(key)
+		k.SetValue(value, data)
+		return p.RegistryImportKey(k)
 	}
 
 	args := []string{"add", key, "/t", t, "/d", d, "/f"}
@@ -56,119 +67,223 @@ func (p *Prefix) RegistryDelete(key, value string) error {
 	return nil
 }
 
+// RegistryImportError is returned when regedit failed to import a
+// registry file, with the line of the registry file it failed at.
+type RegistryImportError struct {
+	Line int    // line number, starting at 1, or 0 if unknown
+	Text string // the line, if known
+	Msg  string // the message written by regedit
+}
+
+func (e *RegistryImportError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("wine: regedit: line %d: %s", e.Line, e.Msg)
+	}
+	return "wine: regedit: " + e.Msg
+}
+
 // RegistryImport imports keys, values and data from a given registry file
 // data into the Wineprefix's registry.
+//
+// See [Prefix.RegistryImportKey] for how the registry file is validated
+// and how errors are returned.
 func (p *Prefix) RegistryImportFile(name string) error {
-	// 'reg' does not support reading from stdin, but regedit
-	// does, and on an error, a dialog will appear instead.
-	cmd := p.Wine("regedit", "/C", name)
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-	return cmd.Run()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if err := p.registryImport(data); err != nil {
+		var perr *ParseError
+		if errors.As(err, &perr) {
+			perr.File = name
+		}
+		return err
+	}
+	return nil
 }
 
-// RegistryKeyImport imports the given key to the Wineprefix. If the root
-// key is not a toplevel registry key, an error will be shown to the user
-// as a GUI.
+// RegistryKeyImport imports the given key to the Wineprefix.
+//
+// Before running regedit, the keys are checked to be within a root key
+// such as HKEY_CURRENT_USER, and the values to be of types regedit
+// supports, returning a [*ParseError] of the first offending line
+// otherwise. regedit is run without a display as to never show a dialog,
+// and the first error it reports is returned as a [*RegistryImportError].
+// The Wineserver is started with [Prefix.Start] beforehand if it is not
+// running, so that it keeps its display.
 func (p *Prefix) RegistryImportKey(key *RegistryKey) error {
-	cmd := p.Wine("regedit", "/C", "-")
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-	cmd.Stdin = nil
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("stdin pipe: %w", err)
+	var buf bytes.Buffer
+	if err := key.Export(&buf); err != nil {
+		return err
 	}
+	return p.registryImport(buf.Bytes())
+}
 
-	if err := cmd.Start(); err != nil {
+// registryImport validates and imports the registry file data with
+// regedit, which reads it from its standard input.
+func (p *Prefix) registryImport(data []byte) error {
+	if err := validateImport(bytes.NewReader(data)); err != nil {
 		return err
 	}
 
-	if err := key.Export(stdin); err != nil {
-		_ = cmd.Process.Kill()
-		_ = cmd.Wait()
+	// The Wineserver is started beforehand, as a session started
+	// by regedit would be left without a display.
+	if err := p.Start(); err != nil {
 		return err
 	}
-	_ = stdin.Close()
+	cmd := p.regeditImport(data)
+	out, err := cmd.CombinedOutput()
+	// regedit may exit with its error reported either way
+	for _, line := range strings.Split(string(out), "\n") {
+		if msg, ok := strings.CutPrefix(line, "regedit: "); ok {
+			return importError(data, strings.TrimSpace(msg))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("regedit: %w", err)
+	}
+	return nil
+}
 
-	return cmd.Wait()
+// regeditImport returns a [Cmd] for regedit importing the registry file
+// data. It is run without a display, as then regedit writes its errors
+// to its output instead of showing them in a dialog.
+func (p *Prefix) regeditImport(data []byte) *Cmd {
+	cmd := p.Wine("regedit", "/C", "-")
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(cmd.Environ(), "DISPLAY=", "WAYLAND_DISPLAY=")
+	return cmd
+}
+
+// validateImport checks that the keys of the registry file from r are
+// within a root key regedit accepts, and that its values are of types
+// regedit supports.
+func validateImport(r io.Reader) error {
+	s := newRegistryScanner(r)
+	if s.err == nil && s.wine {
+		return s.error(1, errors.New("cannot import Wine registry files"))
+	}
+	for s.scan() {
+		switch rec := s.rec; rec.kind {
+		case recordKey, recordKeyDelete:
+			// regedit does not accept abbreviated root key names
+			root, rel, _ := strings.Cut(rec.path, `\`)
+			col := 2
+			if rec.kind == recordKeyDelete {
+				col++
+			}
+			if !isRootName(root) || !strings.EqualFold(rootName(root), root) {
+				return s.error(col, fmt.Errorf("invalid root key: %s", root))
+			}
+			if rec.kind == recordKeyDelete && rel == "" {
+				return s.error(col, errors.New("cannot delete root key"))
+			}
+		case recordValue:
+			// The str(n) types are exclusive to Wine's registry files
+			name, raw, _ := cutValue(s.text)
+			if strings.HasPrefix(raw, "str(") {
+				return s.error(len(name)+2, errors.New("unsupported data type"))
+			}
+		}
+	}
+	return s.err
+}
+
+// importError returns a RegistryImportError of regedit's message, with
+// the first line of the registry file data containing the key name or
+// data quoted in the message.
+func importError(data []byte, msg string) error {
+	e := &RegistryImportError{Msg: msg}
+	i, j := strings.IndexAny(msg, "'["), strings.LastIndexAny(msg, "']")
+	if i < 0 || j <= i+1 {
+		return e
+	}
+	quoted := msg[i+1 : j]
+
+	s := newRegistryScanner(bytes.NewReader(data))
+	for s.scan() {
+		if strings.Contains(s.text, quoted) {
+			e.Line, e.Text = s.start, s.text
+			break
+		}
+	}
+	return e
 }
 
 // RegistryQuery finds the registry key located at path. If the named registry key
 // is not found, nil will be returned.
+//
+// The key is exported with regedit to a temporary file within the
+// Wineprefix and parsed with [RegistryKey.Import], retaining the types of
+// its values as done when parsing the registry files. The returned key's
+// parents are the keys of its path, up to the root key.
 func (p *Prefix) RegistryQuery(path string) (*RegistryKey, error) {
-	args := []string{"query", path, "/s"}
-
-	data, err := p.registryCmd(args...)
+	// regedit cannot write to Unix paths, and C:\windows\temp is
+	// always present within a Wineprefix.
+	f, err := os.CreateTemp(filepath.Join(p.dir, "drive_c", "windows", "temp"), "regedit.*.reg")
 	if err != nil {
-		if strings.Contains(err.Error(), "Unable to find the specified registry key") {
-			return nil, nil
-		}
 		return nil, err
 	}
+	name := f.Name()
+	_ = f.Close()
+	defer os.Remove(name)
 
-	var reg Registry
-	var subkey *RegistryKey
-
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	for scanner.Scan() {
-		line := scanner.Text()
-		out := strings.Split(line, "    ")
+	// regedit only accepts the full names of root keys
+	root, rel, _ := strings.Cut(path, `\`)
+	cmd := p.Wine("regedit", "/E", `C:\windows\temp\`+filepath.Base(name),
+		joinPath(rootName(root), rel))
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
 
-		switch len(out) {
-		case 1:
-			path := out[0]
-			if path == "" {
-				subkey = nil
-				continue
-			}
-			subkey = reg.queryPath(path, true)
-		case 4:
-			if subkey == nil {
-				return nil, errors.New("wine: value without key")
-			}
-			data, err := parseCmdData(out[2], out[3])
-			if err != nil {
-				return nil, fmt.Errorf("subkey %s: %w", out[1], err)
-			}
-			subkey.SetValue(out[1], data)
-		}
+	// regedit exits successfully without writing the file if the
+	// key could not be opened.
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		return nil, nil
 	}
 
-	if k := reg.Query(path); k != nil {
-		return k, nil
+	exported, err := ParseRegistryFile(name)
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("wine: expected successful key creation")
-}
 
-func parseCmdData(dataType string, data string) (RegistryData, error) {
-	switch dataType {
-	case "REG_SZ", "REG_MULTI_SZ":
-		return data, nil
-	case "REG_DWORD":
-		u, err := strconv.ParseUint(data, 0, 32)
-		if err != nil {
-			return nil, err
-		}
-		return uint32(u), nil
-	case "REG_QWORD":
-		return strconv.ParseUint(data, 0, 64)
-	case "REG_BINARY":
-		return hex.DecodeString(data)
-	case "REG_NONE":
-		return byte(0), nil
-	}
-	return nil, fmt.Errorf("unhandled type %s", dataType)
+	k := exported.subkey(rootName(root))
+	if k == nil {
+		return nil, nil
+	}
+	k.parent = nil
+	return k.Query(rel), nil
 }
 
+// formatRegistryData returns the reg type and data arguments of the data,
+// or an empty type if reg cannot express it.
 func formatRegistryData(data any) (string, string) {
 	switch d := data.(type) {
 	case string:
 		return "REG_SZ", d
+	case ExpandableString:
+		return "REG_EXPAND_SZ", string(d)
 	case []string:
-		return "REG_MULTI_SZ", strings.Join([]string(d), "\x00") + "\x00\x00"
+		// reg splits the strings by the literal \0 separator
+		for _, s := range d {
+			if strings.Contains(s, `\0`) {
+				return "", ""
+			}
+		}
+		return "REG_MULTI_SZ", strings.Join(d, `\0`)
 	case uint32:
 		return "REG_DWORD", strconv.FormatUint(uint64(d), 10)
+	case DwordLE:
+		// REG_DWORD_LITTLE_ENDIAN is the same type as REG_DWORD
+		return "REG_DWORD", strconv.FormatUint(uint64(d), 10)
 	case uint64:
 		return "REG_QWORD", strconv.FormatUint(uint64(d), 10)
 	case []byte:
@@ -176,6 +291,8 @@ func formatRegistryData(data any) (string, string) {
 	case byte:
 		return "REG_NONE", "" // value ignored by reg
 	default:
+		// reg writes REG_DWORD_BIG_ENDIAN data as little endian,
+		// and has no types for the rest.
 		return "", ""
 	}
 }
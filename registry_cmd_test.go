@@ -1,6 +1,8 @@
 package wine
 
 import (
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -13,7 +15,9 @@ func TestPrefixCmdOperations(t *testing.T) {
 	key := NewRegistryKey(path)
 	key.Values = []RegistryValue{
 		{Name: "Bar", Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		{Name: "Baz    Qux", Data: []string{"Foo    Bar", "Baz"}},
 		{Name: "Foo", Data: uint32(0xdeadbeef)},
+		{Name: "Quux", Data: ExpandableString(`%SystemRoot%\Foo`)},
 	}
 
 	if err := testPfx.RegistryImportKey(key); err != nil {
@@ -28,14 +32,14 @@ func TestPrefixCmdOperations(t *testing.T) {
 		t.Fatal("expected key query")
 	}
 
-	if root := k.Root(); !root.Equal(&RegistryKey{
+	if root := k.Root(); !root.EqualWith(&RegistryKey{
 		Name:    "HKEY_CURRENT_USER",
 		Subkeys: []*RegistryKey{{Name: "Software", Subkeys: []*RegistryKey{key}}},
-	}) {
+	}, EqualOptions{IgnoreModified: true}) {
 		t.Fatalf("expected root key match, got %v", root)
 	}
 
-	if !k.Equal(key) {
+	if !k.EqualWith(key, EqualOptions{IgnoreModified: true}) {
 		t.Fatalf("expected key match %#+v, got %#+v", key, k)
 	}
 
@@ -51,3 +55,84 @@ func TestPrefixCmdOperations(t *testing.T) {
 		t.Fatalf("expected key deleted, got %v", k)
 	}
 }
+
+func TestRegistryFormatData(t *testing.T) {
+	for _, tt := range []struct {
+		data    RegistryData
+		typ, in string
+	}{
+		{"Foo", "REG_SZ", "Foo"},
+		{ExpandableString(`%SystemRoot%\Foo`), "REG_EXPAND_SZ", `%SystemRoot%\Foo`},
+		{[]string{"Foo", "Bar"}, "REG_MULTI_SZ", `Foo\0Bar`},
+		{[]string{`Foo\0`}, "", ""},
+		{uint32(0xdeadbeef), "REG_DWORD", "3735928559"},
+		{DwordLE(1), "REG_DWORD", "1"},
+		{uint64(1 << 63), "REG_QWORD", "9223372036854775808"},
+		{[]byte{0xde, 0xad}, "REG_BINARY", "dead"},
+		{DwordBE(1), "", ""},
+		{Link(`\Registry\Machine\Software`), "", ""},
+		{BinaryString{0x48, 0x0}, "", ""},
+		{InternalBytes{0xff, []byte{0xde}}, "", ""},
+	} {
+		if typ, in := formatRegistryData(tt.data); typ != tt.typ || in != tt.in {
+			t.Errorf("%#v: expected %s %q, got %s %q", tt.data, tt.typ, tt.in, typ, in)
+		}
+	}
+}
+
+func TestRegistryImportValidate(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		err  *ParseError
+	}{
+		{userExported, nil},
+		{diffExported, nil},
+		{userData, &ParseError{Line: 1, Column: 1, Text: headerWine}},
+		{headerExport + "\n\n[HKCU\\Foo]\n", &ParseError{Line: 3, Column: 2, Text: `[HKCU\Foo]`}},
+		{headerExport + "\n\n[-HKEY_USERS]\n", &ParseError{Line: 3, Column: 3, Text: `[-HKEY_USERS]`}},
+		{headerExport + "\n[HKEY_CURRENT_USER]\n\"Foo\"=str(2):\"Bar\"\n",
+			&ParseError{Line: 3, Column: 7, Text: `"Foo"=str(2):"Bar"`}},
+	} {
+		err := validateImport(strings.NewReader(tt.data))
+		if tt.err == nil {
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			continue
+		}
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("%q: expected parse error, got %v", tt.data, err)
+			continue
+		}
+		perr.Err = nil
+		if *perr != *tt.err {
+			t.Errorf("%q: expected %#v, got %#v", tt.data, *tt.err, *perr)
+		}
+	}
+
+	err := importError([]byte(userExported), `Unable to open the registry key 'HKEY_CURRENT_USER\Foo\Bar'.`)
+	if e, ok := err.(*RegistryImportError); !ok || e.Line != 15 ||
+		e.Text != `[HKEY_CURRENT_USER\Foo\Bar]` {
+		t.Fatalf("expected import error with offending line, got %#v", err)
+	}
+}
+
+func TestRegistryImportHeadless(t *testing.T) {
+	t.Setenv("DISPLAY", ":0")
+	t.Setenv("WAYLAND_DISPLAY", "wayland-0")
+	pfx := New(t.TempDir(), "")
+	if pfx.Running() {
+		t.Fatal("expected stopped prefix")
+	}
+
+	env := make(map[string]string)
+	for _, kv := range pfx.regeditImport(nil).Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		env[k] = v
+	}
+	if env["DISPLAY"] != "" || env["WAYLAND_DISPLAY"] != "" {
+		t.Fatalf("expected no display, got %q and %q",
+			env["DISPLAY"], env["WAYLAND_DISPLAY"])
+	}
+}
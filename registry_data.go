@@ -16,6 +16,11 @@ type (
 	Link             string // hex(6): REG_LINK
 )
 
+// Deleted marks a registry value as removed, and is exported with the
+// '"Value"=-' deletion syntax when exporting for regedit. It is never
+// written to Wine's registry files.
+type Deleted struct{}
+
 // InternalBytes represents a custom registry value type
 // hex(i) where i is the Identifier. This is found in
 // DEVPROP_TYPE_DEVPROPTYPE.
@@ -26,9 +31,8 @@ type InternalBytes struct {
 
 // RegistryData represents a RegistryValue's data.
 //
-// Setting the data to nil will effectively delete
-// the data during exporting, but will not delete
-// the registry key.
+// Values with nil data are not exported; to delete a value when
+// exporting, set its data to [Deleted].
 //
 // Known registry types and their Go types:
 //   - REG_SZ = string
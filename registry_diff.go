@@ -0,0 +1,331 @@
+package wine
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// ChangeKind represents the kind of a [RegistryChange].
+type ChangeKind int
+
+const (
+	KeyAdded ChangeKind = iota
+	KeyRemoved
+	ValueAdded
+	ValueRemoved
+	ValueChanged
+)
+
+// String implements the Stringer interface.
+func (c ChangeKind) String() string {
+	switch c {
+	case KeyAdded:
+		return "key added"
+	case KeyRemoved:
+		return "key removed"
+	case ValueAdded:
+		return "value added"
+	case ValueRemoved:
+		return "value removed"
+	case ValueChanged:
+		return "value changed"
+	}
+	return fmt.Sprintf("ChangeKind(%d)", int(c))
+}
+
+// RegistryChange represents a single change made to a registry key
+// or one of its values.
+type RegistryChange struct {
+	Kind ChangeKind
+
+	// Path is the absolute path of the changed registry key, or
+	// the key of the changed value.
+	Path string
+
+	// Name is the name of the changed value, and is empty for
+	// changes made to the (Default) value or a registry key.
+	Name string
+
+	// Old and New are the data of the value prior to and after the
+	// change, and are nil if the value did not exist.
+	Old RegistryData
+	New RegistryData
+}
+
+// RegistryDiff is a list of changes between two registry keys, in the
+// order they are to be applied.
+type RegistryDiff []RegistryChange
+
+// Diff reports the changes required to turn the registry key a into b,
+// including all of their subkeys. Keys removed in b will be reported
+// only once, without reporting their subkeys and values.
+//
+// The paths of the changes are relative to the path of b.
+func Diff(a, b *RegistryKey) RegistryDiff {
+	var d RegistryDiff
+	switch {
+	case a == nil && b == nil:
+	case a == nil:
+		d.added(b.Path(), b)
+	case b == nil:
+		d = append(d, RegistryChange{Kind: KeyRemoved, Path: a.Path()})
+	default:
+		d.diff(b.Path(), a, b)
+	}
+	return d
+}
+
+func (d *RegistryDiff) diff(path string, a, b *RegistryKey) {
+	for _, v := range a.Values {
+		if b.GetValue(v.Name) == nil {
+			*d = append(*d, RegistryChange{
+				Kind: ValueRemoved, Path: path, Name: v.Name, Old: v.Data,
+			})
+		}
+	}
+	for _, v := range b.Values {
+		old := a.GetValue(v.Name)
+		switch {
+		case old == nil:
+			*d = append(*d, RegistryChange{
+				Kind: ValueAdded, Path: path, Name: v.Name, New: v.Data,
+			})
+		case !reflect.DeepEqual(old.Data, v.Data):
+			*d = append(*d, RegistryChange{
+				Kind: ValueChanged, Path: path, Name: v.Name, Old: old.Data, New: v.Data,
+			})
+		}
+	}
+
+	for _, sk := range a.Subkeys {
+		if b.subkey(sk.Name) == nil {
+			*d = append(*d, RegistryChange{
+				Kind: KeyRemoved, Path: joinPath(path, sk.Name),
+			})
+		}
+	}
+	for _, sk := range b.Subkeys {
+		if old := a.subkey(sk.Name); old != nil {
+			d.diff(joinPath(path, sk.Name), old, sk)
+		} else {
+			d.added(joinPath(path, sk.Name), sk)
+		}
+	}
+}
+
+func (d *RegistryDiff) added(path string, k *RegistryKey) {
+	*d = append(*d, RegistryChange{Kind: KeyAdded, Path: path})
+	for _, v := range k.Values {
+		*d = append(*d, RegistryChange{
+			Kind: ValueAdded, Path: path, Name: v.Name, New: v.Data,
+		})
+	}
+	for _, sk := range k.Subkeys {
+		d.added(joinPath(path, sk.Name), sk)
+	}
+}
+
+// Export writes d to w as a regedit patch, which can be imported with
+// [RegistryKey.Import] or [Prefix.RegistryImportFile] to apply it.
+//
+// Removed keys and values are written using the '[-Key]' and '"Value"=-'
+// deletion syntax respectively.
+func (d RegistryDiff) Export(w io.Writer) error {
+	if _, err := io.WriteString(w, headerExport+"\n"); err != nil {
+		return err
+	}
+
+	var key string
+	for _, c := range d {
+		var err error
+		switch c.Kind {
+		case KeyRemoved:
+			_, err = fmt.Fprintf(w, "\n[-%s]\n", Escape(c.Path, false, true))
+			key = ""
+		case KeyAdded:
+			_, err = fmt.Fprintf(w, "\n[%s]\n", Escape(c.Path, false, true))
+			key = c.Path
+		case ValueAdded, ValueChanged, ValueRemoved:
+			if c.Path != key {
+				_, err = fmt.Fprintf(w, "\n[%s]\n", Escape(c.Path, false, true))
+				key = c.Path
+			}
+			v := RegistryValue{c.Name, c.New}
+			if c.Kind == ValueRemoved {
+				v.Data = Deleted{}
+			}
+			if err == nil {
+				err = v.export(w, false)
+			}
+		default:
+			err = fmt.Errorf("wine: unhandled registry change: %v", c.Kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import parses the regedit patch or registry file from r, appending its
+// keys and values as additions and its deletions as removals to d.
+// Unlike [RegistryKey.Import], the deletions are kept, allowing d to be
+// applied onto an existing registry key with [RegistryKey.Apply].
+func (d *RegistryDiff) Import(r io.Reader) error {
+	var key string
+	s := newRegistryScanner(r)
+	for s.scan() {
+		switch rec := s.rec; rec.kind {
+		case recordKey:
+			key = joinPath(s.root, rec.path)
+			*d = append(*d, RegistryChange{Kind: KeyAdded, Path: key})
+		case recordKeyDelete:
+			*d = append(*d, RegistryChange{Kind: KeyRemoved, Path: joinPath(s.root, rec.path)})
+		case recordValue:
+			*d = append(*d, RegistryChange{Kind: ValueAdded, Path: key, Name: rec.name, New: rec.data})
+		case recordValueDelete:
+			*d = append(*d, RegistryChange{Kind: ValueRemoved, Path: key, Name: rec.name})
+		}
+	}
+	return s.err
+}
+
+// RegistryConflict represents a change that was not applied, as the
+// registry key it was applied to was not in the state it expected.
+type RegistryConflict struct {
+	Change RegistryChange
+
+	// Current is the data of the changed value at the time the
+	// change was applied, and is nil if it did not exist.
+	Current RegistryData
+}
+
+// ConflictError is returned when changes could not be applied.
+type ConflictError struct {
+	Conflicts []RegistryConflict
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("wine: %d conflicting registry changes", len(e.Conflicts))
+}
+
+// Apply applies the changes in d onto k's tree, and returns the changes
+// that were made, with their Old data set to the data that was replaced
+// or removed. Changes that had no effect, such as removing a missing
+// value, are omitted. The paths of the changes must be within
+// k's root key.
+//
+// A change conflicts if its Old data is set, such as with changes from
+// [Diff], and does not match the value's current data, or if its key is
+// outside of k's tree or is a root key to be removed. Conflicting changes
+// are skipped and returned in a [ConflictError].
+func (k *RegistryKey) Apply(d RegistryDiff) (RegistryDiff, error) {
+	return d.apply(k.resolvePath)
+}
+
+// Apply is like [RegistryKey.Apply], with the paths of the changes being
+// resolved as done by [Registry.Query].
+func (r *Registry) Apply(d RegistryDiff) (RegistryDiff, error) {
+	return d.apply(r.queryPath)
+}
+
+func (d RegistryDiff) apply(lookup func(string, bool) *RegistryKey) (RegistryDiff, error) {
+	var applied RegistryDiff
+	var conflicts []RegistryConflict
+
+	// create finds or creates the key at path, recording
+	// all of the keys that had to be created.
+	create := func(path string) *RegistryKey {
+		var missing []string
+		for p := path; lookup(p, false) == nil; {
+			missing = append(missing, p)
+			i := strings.LastIndexByte(p, '\\')
+			if i < 0 {
+				break
+			}
+			p = p[:i]
+		}
+		k := lookup(path, true)
+		if k == nil {
+			return nil
+		}
+		for _, p := range slices.Backward(missing) {
+			applied = append(applied, RegistryChange{Kind: KeyAdded, Path: p})
+		}
+		return k
+	}
+
+	for _, c := range d {
+		k := lookup(c.Path, false)
+		var current RegistryData
+		if k != nil {
+			if v := k.GetValue(c.Name); v != nil {
+				current = v.Data
+			}
+		}
+		if c.Kind == KeyAdded || c.Kind == KeyRemoved {
+			current = nil
+		}
+		if c.Old != nil && !reflect.DeepEqual(c.Old, current) {
+			conflicts = append(conflicts, RegistryConflict{c, current})
+			continue
+		}
+
+		switch c.Kind {
+		case KeyAdded:
+			if k == nil && create(c.Path) == nil {
+				conflicts = append(conflicts, RegistryConflict{Change: c})
+			}
+		case KeyRemoved:
+			if k == nil {
+				continue
+			}
+			if k.parent == nil {
+				conflicts = append(conflicts, RegistryConflict{Change: c})
+				continue
+			}
+			k.parent.Delete(k.Name)
+			applied = append(applied, RegistryChange{Kind: KeyRemoved, Path: c.Path})
+		case ValueAdded, ValueChanged:
+			if c.New == nil {
+				conflicts = append(conflicts, RegistryConflict{c, current})
+				continue
+			}
+			if reflect.DeepEqual(c.New, current) {
+				continue
+			}
+			if k == nil {
+				if k = create(c.Path); k == nil {
+					conflicts = append(conflicts, RegistryConflict{Change: c})
+					continue
+				}
+			}
+			v := k.GetValue(c.Name)
+			change := RegistryChange{Kind: ValueAdded, Path: c.Path, Name: c.Name, New: c.New}
+			if v != nil {
+				change.Kind, change.Name, change.Old = ValueChanged, v.Name, v.Data
+			}
+			k.SetValue(c.Name, c.New)
+			applied = append(applied, change)
+		case ValueRemoved:
+			if k == nil || current == nil {
+				continue
+			}
+			name := k.GetValue(c.Name).Name
+			k.DeleteValue(c.Name)
+			applied = append(applied, RegistryChange{
+				Kind: ValueRemoved, Path: c.Path, Name: name, Old: current,
+			})
+		default:
+			conflicts = append(conflicts, RegistryConflict{c, current})
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return applied, &ConflictError{conflicts}
+	}
+	return applied, nil
+}
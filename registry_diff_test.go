@@ -0,0 +1,164 @@
+package wine
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegistryDiff(t *testing.T) {
+	a, b := testdata(), testdata()
+	foo := b.Query("Foo")
+	foo.SetValue("value c", uint32(1))
+	foo.DeleteValue("Value B")
+	b.Add(`Quux\Quuz`).SetValue("Value N", "Quuz")
+	b.Delete(`Foo\Bar`)
+
+	d := Diff(a, b)
+	if !reflect.DeepEqual(d, RegistryDiff{
+		{Kind: ValueRemoved, Path: `HKEY_CURRENT_USER\Foo`, Name: "Value B",
+			Old: []byte{0xde, 0xad, 0xbe, 0xef, 0x0, 0x0}},
+		{Kind: ValueChanged, Path: `HKEY_CURRENT_USER\Foo`, Name: "Value C",
+			Old: uint32(0xdeadbeef), New: uint32(1)},
+		{Kind: KeyRemoved, Path: `HKEY_CURRENT_USER\Foo\Bar`},
+		{Kind: KeyAdded, Path: `HKEY_CURRENT_USER\Quux`},
+		{Kind: KeyAdded, Path: `HKEY_CURRENT_USER\Quux\Quuz`},
+		{Kind: ValueAdded, Path: `HKEY_CURRENT_USER\Quux\Quuz`, Name: "Value N", New: "Quuz"},
+	}) {
+		t.Fatalf("unexpected diff %#v", d)
+	}
+
+	if d := Diff(a, testdata()); len(d) != 0 {
+		t.Fatalf("expected no changes, got %v", d)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := d.Export(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if x := buf.String(); x != diffExported {
+		t.Errorf("data unexportable")
+		t.Log(x)
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		root := &RegistryKey{Subkeys: []*RegistryKey{a}}
+		a.parent = root
+		if err := root.Import(strings.NewReader(diffExported)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if d := Diff(a, b); len(d) != 0 {
+			t.Fatalf("expected no changes after patch, got %v", d)
+		}
+	})
+}
+
+const diffExported = `Windows Registry Editor Version 5.00
+
+[HKEY_CURRENT_USER\Foo]
+"Value B"=-
+"Value C"=dword:00000001
+
+[-HKEY_CURRENT_USER\Foo\Bar]
+
+[HKEY_CURRENT_USER\Quux]
+
+[HKEY_CURRENT_USER\Quux\Quuz]
+"Value N"="Quuz"
+`
+
+func TestRegistryApply(t *testing.T) {
+	a, b := testdata(), testdata()
+	b.Query("Foo").SetValue("Value C", uint32(1))
+	b.Query("Foo").DeleteValue("Value B")
+	b.Add(`Quux\Quuz`).SetValue("Value N", "Quuz")
+	b.Delete(`Foo\Bar`)
+	d := Diff(a, b)
+
+	applied, err := a.Apply(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(applied, d) {
+		t.Fatalf("expected applied changes %v, got %v", d, applied)
+	}
+	if d := Diff(a, b); len(d) != 0 {
+		t.Fatalf("expected no changes after apply, got %v", d)
+	}
+
+	t.Run("idempotent", func(t *testing.T) {
+		var patch RegistryDiff
+		if err := patch.Import(strings.NewReader(diffExported)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		applied, err := a.Apply(patch)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(applied) != 0 {
+			t.Fatalf("expected no applied changes, got %v", applied)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		c := testdata()
+		c.Query("Foo").SetValue("Value C", uint32(2))
+
+		applied, err := c.Apply(d)
+		var ce *ConflictError
+		if !errors.As(err, &ce) {
+			t.Fatalf("expected conflict error, got %v", err)
+		}
+		if !reflect.DeepEqual(ce.Conflicts, []RegistryConflict{{d[1], uint32(2)}}) {
+			t.Fatalf("unexpected conflicts %v", ce.Conflicts)
+		}
+		if len(applied) != len(d)-1 {
+			t.Fatalf("expected other changes applied, got %v", applied)
+		}
+		if v := c.Query("Foo").GetValue("Value C"); v.Data != uint32(2) {
+			t.Fatalf("expected conflicting value untouched, got %v", v.Data)
+		}
+	})
+
+	t.Run("registry", func(t *testing.T) {
+		var patch RegistryDiff
+		if err := patch.Import(strings.NewReader(`Windows Registry Editor Version 5.00
+
+[HKEY_CLASSES_ROOT\.txt]
+@="txtfile"
+
+[HKEY_CURRENT_USER\Software\Foo]
+"Value A"=-
+
+[-HKEY_LOCAL_MACHINE\Software\Bar]
+
+[-HKEY_CURRENT_USER]
+`)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var reg Registry
+		reg.queryPath(`HKLM\Software\Bar`, true)
+		reg.queryPath(`HKCU\Software\Foo`, true).SetValue("Value A", "")
+
+		applied, err := reg.Apply(patch)
+		var ce *ConflictError
+		if !errors.As(err, &ce) || len(ce.Conflicts) != 1 || ce.Conflicts[0].Change != patch[5] {
+			t.Fatalf("expected root deletion conflict, got %v", err)
+		}
+		if !reflect.DeepEqual(applied, RegistryDiff{
+			{Kind: KeyAdded, Path: `HKEY_CLASSES_ROOT`},
+			{Kind: KeyAdded, Path: `HKEY_CLASSES_ROOT\.txt`},
+			{Kind: ValueAdded, Path: `HKEY_CLASSES_ROOT\.txt`, New: "txtfile"},
+			{Kind: ValueRemoved, Path: `HKEY_CURRENT_USER\Software\Foo`, Name: "Value A", Old: ""},
+			{Kind: KeyRemoved, Path: `HKEY_LOCAL_MACHINE\Software\Bar`},
+		}) {
+			t.Fatalf("unexpected applied changes %#v", applied)
+		}
+		if k := reg.Query(`HKLM\Software\Classes\.txt`); k == nil || k.GetValue("") == nil {
+			t.Fatalf("expected class key creation, got %v", k)
+		}
+	})
+}
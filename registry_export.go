@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
 	"unicode/utf16"
 )
@@ -12,26 +13,68 @@ import (
 const (
 	headerWine   = `WINE REGISTRY Version 2`
 	headerExport = `Windows Registry Editor Version 5.00`
+	headerANSI   = `REGEDIT4`
 )
 
 // Export writes the regedit export of k to w. Any error regarding
 // formatting a type will not be returned if k's origin was serialized
 // from ParseRegistry.
 //
-// If there are any registry keys with no values and subkeys, it will
-// be marked as deleted.
+// Keys marked as Deleted and values with [Deleted] data are written with
+// the '[-Key]' and '"Value"=-' deletion syntax respectively, and values
+// with nil data are not written.
 //
-// Registry keys that are links to other keys will not be exported here.
+// Registry keys that are links to other keys are exported with the values
+// and subkeys of the key they link to, as done by regedit. Links to keys
+// outside of k's tree will not be exported.
 func (k *RegistryKey) Export(w io.Writer) error {
+	return k.ExportWith(w, ExportOptions{})
+}
+
+// ExportOptions specify the encoding used by [RegistryKey.ExportWith].
+type ExportOptions struct {
+	// UTF16 encodes the export as UTF-16LE with a byte order mark,
+	// as written by Windows' regedit.
+	UTF16 bool
+
+	// CRLF ends each line with CRLF rather than LF.
+	CRLF bool
+
+	// Canonical writes keys and values sorted by their names, in the
+	// order the Wineserver keeps and writes them, rather than in the
+	// order they are in. Keys are also only written with their own line
+	// when the Wineserver would write them to its registry files.
+	Canonical bool
+}
+
+// ExportWith is like [RegistryKey.Export], writing the export of k
+// to w with the given options.
+func (k *RegistryKey) ExportWith(w io.Writer, opts ExportOptions) error {
+	if opts.UTF16 {
+		if _, err := w.Write([]byte{0xFF, 0xFE}); err != nil {
+			return err
+		}
+	}
+	tw := &textWriter{w: w, utf16: opts.UTF16, crlf: opts.CRLF}
+	if opts.UTF16 || opts.CRLF {
+		w = tw
+	}
+
 	_, err := io.WriteString(w, headerExport+"\n")
 	if err != nil {
 		return err
 	}
 
-	return k.export(false, w)
+	if err := k.export(w, false, opts); err != nil {
+		return err
+	}
+	return tw.Close()
 }
 
-func (k *RegistryKey) exportSystem(w io.Writer) error {
+// exportSystem writes k as the Wine registry file of its root key. Only
+// the Canonical option is used, as Wine registry files are always UTF-8
+// with LF line endings.
+func (k *RegistryKey) exportSystem(w io.Writer, opts ExportOptions) error {
 	_, err := io.WriteString(w, headerWine+"\n;; All keys relative to ")
 	if err != nil {
 		return err
@@ -41,47 +84,89 @@ func (k *RegistryKey) exportSystem(w io.Writer) error {
 		_, err = io.WriteString(w, `REGISTRY\\User\\`+sid)
 	case `HKEY_LOCAL_MACHINE`:
 		_, err = io.WriteString(w, `REGISTRY\\Machine`)
+	case `HKEY_USERS\.Default`:
+		_, err = io.WriteString(w, `REGISTRY\\User\\.Default`)
 	}
 	if err != nil {
 		return err
 	}
-	if _, err := io.WriteString(w, "\n\n#arch=win64\n"); err != nil {
+	if _, err := io.WriteString(w, "\n\n#arch="+k.Arch()+"\n"); err != nil {
 		return err
 	}
 
-	return k.export(true, w)
+	return k.export(w, true, opts)
 }
 
-func (k *RegistryKey) export(wine bool, w io.Writer) error {
-	// TODO: support links for regedit export
-	if k.link && !wine {
-		return nil
+func (k *RegistryKey) export(w io.Writer, wine bool, opts ExportOptions) error {
+	if wine {
+		return k.exportPath(k.pathWine(), wine, opts, w, nil)
 	}
+	return k.exportPath(k.Path(), wine, opts, w, nil)
+}
 
-	if len(k.Values) == 0 && len(k.Subkeys) == 0 && !wine {
-		_, err := fmt.Fprintf(w, "\n[-%s]\n", Escape(k.Path(), false, !wine))
+// exportPath writes k as the registry key at path. When exporting for
+// regedit, link keys are followed and their target's values and subkeys
+// written in place of the link, with links holding the targets currently
+// being written to prevent cycles.
+func (k *RegistryKey) exportPath(path string, wine bool, opts ExportOptions, w io.Writer, links []*RegistryKey) error {
+	if k.Deleted {
+		if wine {
+			return nil
+		}
+		_, err := fmt.Fprintf(w, "\n[-%s]\n", Escape(path, false, !wine))
 		return err
 	}
+	if k.link && !wine {
+		target := k.follow(k.resolve)
+		if target == nil || slices.Contains(links, target) {
+			return nil
+		}
+		links = append(links, target)
+		k = target
+	}
 	// TODO: regedit randomly decides if keys with no values have their own line
-	if len(k.Values) > 0 || (wine && !k.modified.IsZero()) {
+	line := len(k.Values) > 0 || (!wine && len(k.Subkeys) == 0) ||
+		(wine && (!k.modified.IsZero() || k.class != "" || k.link))
+	if opts.Canonical && wine {
+		// As done by save_subkeys in server/registry.c
+		line = len(k.Values) > 0 || len(k.Subkeys) == 0 || k.class != "" || k.link
+	}
+	if line {
 		var err error
 		if !wine {
 			// If exporting, the raw bytes are given out
-			_, err = fmt.Fprintf(w, "\n[%s]\n", Escape(k.Path(), false, !wine))
+			_, err = fmt.Fprintf(w, "\n[%s]\n", Escape(path, false, !wine))
 		} else {
 			_, err = fmt.Fprintf(w, "\n[%s] %d\n#time=%x\n",
-				Escape(k.pathWine(), false, !wine), k.modified.Unix(), k.modified)
+				Escape(path, false, !wine), k.modified.Unix(), k.modified)
 		}
 		if err != nil {
 			return err
 		}
 	}
-	if k.link {
+	if k.class != "" && wine {
+		if _, err := io.WriteString(w, `#class="`+Escape(k.class, true, false)+"\"\n"); err != nil {
+			return err
+		}
+	}
+	if k.link && wine {
 		if _, err := io.WriteString(w, "#link\n"); err != nil {
 			return err
 		}
 	}
-	for _, v := range k.Values {
+	for _, d := range k.directives {
+		if !wine {
+			break
+		}
+		if _, err := io.WriteString(w, d+"\n"); err != nil {
+			return err
+		}
+	}
+	values, subkeys := k.Values, k.Subkeys
+	if opts.Canonical {
+		values, subkeys = sortedValues(values), sortedKeys(subkeys)
+	}
+	for _, v := range values {
 		err := v.export(w, wine)
 		if err != nil {
 			return err
@@ -89,8 +174,8 @@ func (k *RegistryKey) export(wine bool, w io.Writer) error {
 
 	}
 
-	for _, sk := range k.Subkeys {
-		err := sk.export(wine, w)
+	for _, sk := range subkeys {
+		err := sk.exportPath(joinPath(path, sk.Name), wine, opts, w, links)
 		if err != nil {
 			return err
 		}
@@ -98,6 +183,22 @@ func (k *RegistryKey) export(wine bool, w io.Writer) error {
 	return nil
 }
 
+// sortedKeys returns a copy of keys sorted by their names, in the
+// order the Wineserver keeps subkeys.
+func sortedKeys(keys []*RegistryKey) []*RegistryKey {
+	return slices.SortedStableFunc(slices.Values(keys), func(a, b *RegistryKey) int {
+		return compareName(a.Name, b.Name)
+	})
+}
+
+// sortedValues returns a copy of values sorted by their names, in the
+// order the Wineserver keeps values.
+func sortedValues(values []RegistryValue) []RegistryValue {
+	return slices.SortedStableFunc(slices.Values(values), func(a, b RegistryValue) int {
+		return compareName(a.Name, b.Name)
+	})
+}
+
 func (rv RegistryValue) export(w io.Writer, wine bool) error {
 	var payload []byte
 	var (
@@ -105,7 +206,10 @@ func (rv RegistryValue) export(w io.Writer, wine bool) error {
 		pos int
 	)
 
-	if wine && rv.Data == nil {
+	if rv.Data == nil {
+		return nil
+	}
+	if _, ok := rv.Data.(Deleted); ok && wine {
 		return nil
 	}
 
@@ -122,7 +226,7 @@ func (rv RegistryValue) export(w io.Writer, wine bool) error {
 	pos += 6
 
 	switch d := rv.Data.(type) {
-	case nil:
+	case Deleted:
 		_, err = io.WriteString(w, "-")
 	case string:
 		// Dumps normal and quotes in server/registry.c
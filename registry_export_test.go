@@ -4,15 +4,20 @@ import (
 	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func TestRegistryExport(t *testing.T) {
 	root := testdata()
-	root.Query(`Foo\Bar\Baz`).SetValue("Value O", nil)
+	baz := root.Query(`Foo\Bar\Baz`)
+	modified := baz.Modified()
+	baz.SetValue("Value O", Deleted{})
+	baz.SetValue("Value P", nil)
+	baz.SetModified(modified)
 	buf := new(bytes.Buffer) // error cannot occur here
 
-	if err := root.exportSystem(buf); err != nil {
+	if err := root.exportSystem(buf, ExportOptions{}); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	if x := buf.String(); x != userExportedSys {
@@ -22,6 +27,7 @@ func TestRegistryExport(t *testing.T) {
 
 	buf.Reset()
 	t.Run("regedit", func(t *testing.T) {
+		root.Query(`Foo\Quz`).Deleted = true
 		if err := root.Export(buf); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -53,14 +59,14 @@ func TestRegistryPrefixExport(t *testing.T) {
 
 	buf := bytes.Buffer{}
 
-	_ = reg.Machine.exportSystem(&buf)
+	_ = reg.Machine.exportSystem(&buf, ExportOptions{})
 	if b := buf.Bytes(); !bytes.Equal(b, []byte(s)) {
 		t.Log(string(b))
 		t.Fatalf("expected machine key export match")
 	}
 
 	buf.Reset()
-	_ = reg.CurrentUser.exportSystem(&buf)
+	_ = reg.CurrentUser.exportSystem(&buf, ExportOptions{})
 	if b := buf.Bytes(); !bytes.Equal(b, []byte(u)) {
 		t.Log(string(b))
 		t.Fatalf("expected user key export match")
@@ -139,3 +145,166 @@ const userExported = `Windows Registry Editor Version 5.00
 
 [-HKEY_CURRENT_USER\Foo\Quz]
 `
+
+func TestRegistryExportDirectives(t *testing.T) {
+	var k RegistryKey
+	if err := k.Import(strings.NewReader(directivesData)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if arch := k.Arch(); arch != "win32" {
+		t.Errorf("expected win32 arch, got %s", arch)
+	}
+	sub := k.Query(`Hardware\Description\System`)
+	if class := sub.Class(); class != `Multifunction "Adapter"` {
+		t.Errorf("expected key class, got %s", class)
+	}
+	if modified := k.Query(`Software`).modified; modified.Unix() != 1760553029 {
+		t.Errorf("expected key modification time, got %d", modified.Unix())
+	}
+
+	buf := new(bytes.Buffer)
+	if err := k.exportSystem(buf, ExportOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if x := buf.String(); x != directivesExported {
+		t.Errorf("data unreversable")
+		t.Log(x)
+	}
+}
+
+const directivesData = `WINE REGISTRY Version 2
+;; All keys relative to REGISTRY\\Machine
+
+#arch=win32
+
+[Hardware\\Description\\System] 1766588356
+#time=1dc74e5dfeefd32
+#class="Multifunction \"Adapter\""
+#foo=bar
+"Identifier"="AT compatible"
+
+[Software] 1760553029
+`
+
+const directivesExported = `WINE REGISTRY Version 2
+;; All keys relative to REGISTRY\\Machine
+
+#arch=win32
+
+[Hardware\\Description\\System] 1766588356
+#time=1dc74e5dfeefd32
+#class="Multifunction \"Adapter\""
+#foo=bar
+"Identifier"="AT compatible"
+
+[Software] 1760553029
+#time=1dc3e01c8431080
+`
+
+func TestRegistryExportOptions(t *testing.T) {
+	root := testdata()
+	root.SetValue("Value A", "Ünïcode 😀")
+	buf := new(bytes.Buffer)
+	if err := root.ExportWith(buf, ExportOptions{UTF16: true, CRLF: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b := buf.Bytes()
+	if !bytes.HasPrefix(b, []byte{0xFF, 0xFE, 'W', 0}) {
+		t.Fatalf("expected UTF-16LE byte order mark, got %x", b[:4])
+	}
+	if !bytes.Contains(b, []byte{'\r', 0, '\n', 0}) {
+		t.Fatal("expected CRLF line endings")
+	}
+
+	var k RegistryKey
+	if err := k.Import(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var want RegistryKey
+	buf.Reset()
+	_ = root.Export(buf)
+	if err := want.Import(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !k.EqualWith(&want, EqualOptions{IgnoreModified: true}) {
+		t.Errorf("data unreversable")
+		t.Log(registryKeyJSON(&k))
+	}
+}
+
+func TestRegistryExportCanonical(t *testing.T) {
+	root := NewRegistryKey(`HKLM\`)
+	sw := root.Add("Software")
+	for _, name := range []string{`_Foo`, `foo bar`, `Foo\Bar`, `apple`} {
+		sw.Add(name)
+	}
+	for _, name := range []string{"b", "_c", "A", ""} {
+		sw.SetValue(name, name)
+	}
+	for k := range root.All() {
+		k.SetModified(Filetime(0x1dc74e5dfeefd32))
+	}
+
+	buf := new(bytes.Buffer)
+	if err := root.exportSystem(buf, ExportOptions{Canonical: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if x := buf.String(); x != canonicalExportedSys {
+		t.Errorf("data not canonical")
+		t.Log(x)
+	}
+
+	buf.Reset()
+	t.Run("regedit", func(t *testing.T) {
+		if err := root.ExportWith(buf, ExportOptions{Canonical: true}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if x := buf.String(); x != canonicalExported {
+			t.Errorf("data not canonical")
+			t.Log(x)
+		}
+	})
+}
+
+const canonicalExportedSys = `WINE REGISTRY Version 2
+;; All keys relative to REGISTRY\\Machine
+
+#arch=win64
+
+[Software] 1766588356
+#time=1dc74e5dfeefd32
+@=""
+"A"="A"
+"b"="b"
+"_c"="_c"
+
+[Software\\apple] 1766588356
+#time=1dc74e5dfeefd32
+
+[Software\\Foo\\Bar] 1766588356
+#time=1dc74e5dfeefd32
+
+[Software\\foo bar] 1766588356
+#time=1dc74e5dfeefd32
+
+[Software\\_Foo] 1766588356
+#time=1dc74e5dfeefd32
+`
+
+const canonicalExported = `Windows Registry Editor Version 5.00
+
+[HKEY_LOCAL_MACHINE\Software]
+@=""
+"A"="A"
+"b"="b"
+"_c"="_c"
+
+[HKEY_LOCAL_MACHINE\Software\apple]
+
+[HKEY_LOCAL_MACHINE\Software\Foo\Bar]
+
+[HKEY_LOCAL_MACHINE\Software\foo bar]
+
+[HKEY_LOCAL_MACHINE\Software\_Foo]
+`
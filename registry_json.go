@@ -0,0 +1,254 @@
+package wine
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// jsonKey is the JSON representation of a [RegistryKey].
+type jsonKey struct {
+	Name       string          `json:"name"`
+	Modified   *time.Time      `json:"modified,omitempty"`
+	Class      string          `json:"class,omitempty"`
+	Link       bool            `json:"link,omitempty"`
+	Deleted    bool            `json:"deleted,omitempty"`
+	Arch       string          `json:"arch,omitempty"`
+	Directives []string        `json:"directives,omitempty"`
+	Values     []RegistryValue `json:"values,omitempty"`
+	Subkeys    []*RegistryKey  `json:"subkeys,omitempty"`
+}
+
+// jsonValue is the JSON representation of a [RegistryValue].
+type jsonValue struct {
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	Identifier *uint32         `json:"identifier,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+}
+
+// MarshalJSON implements the [json.Marshaler] interface.
+//
+// A registry key is encoded as an object of the following form, where
+// every member but name is omitted if empty:
+//
+//	{
+//		"name": "Software",
+//		"modified": "2024-01-02T15:04:05.1234567Z",
+//		"class": "Shell",
+//		"link": true,
+//		"arch": "win64",
+//		"directives": ["#foo"],
+//		"values": [{"name": "Version", "type": "sz", "data": "win10"}],
+//		"subkeys": [{"name": "Wine"}]
+//	}
+//
+// The modified time is in RFC 3339 format, and arch is only set on root
+// keys parsed from Wine's registry files. See [RegistryValue.MarshalJSON]
+// for the encoding of the values.
+func (k *RegistryKey) MarshalJSON() ([]byte, error) {
+	j := jsonKey{
+		Name:       k.Name,
+		Class:      k.class,
+		Link:       k.link,
+		Deleted:    k.Deleted,
+		Arch:       k.arch,
+		Directives: k.directives,
+		Subkeys:    k.Subkeys,
+	}
+	// Values with nil data are not exported
+	for _, v := range k.Values {
+		if v.Data != nil {
+			j.Values = append(j.Values, v)
+		}
+	}
+	if !k.modified.IsZero() {
+		t := k.modified.Time()
+		j.Modified = &t
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements the [json.Unmarshaler] interface, decoding
+// the encoding described by [RegistryKey.MarshalJSON] into k and setting
+// the parents of its subkeys.
+func (k *RegistryKey) UnmarshalJSON(b []byte) error {
+	var j jsonKey
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+
+	*k = RegistryKey{
+		Name:       j.Name,
+		Values:     j.Values,
+		Subkeys:    j.Subkeys,
+		Deleted:    j.Deleted,
+		parent:     k.parent,
+		link:       j.Link,
+		class:      j.Class,
+		arch:       j.Arch,
+		directives: j.Directives,
+	}
+	if j.Modified != nil {
+		k.modified = FromTime(*j.Modified)
+	}
+	for _, sk := range k.Subkeys {
+		if sk == nil {
+			return fmt.Errorf("wine: registry key %s has a null subkey", k.Name)
+		}
+		sk.parent = k
+	}
+	return nil
+}
+
+// MarshalJSON implements the [json.Marshaler] interface.
+//
+// A registry value is encoded as an object with its name, which is empty
+// for the (Default) value, its type, and its data:
+//
+//	{"name": "Value", "type": "dword", "data": 1}
+//
+// The types and the JSON encoding of their data are:
+//   - sz (string): string
+//   - expand_sz ([ExpandableString]): string
+//   - link ([Link]): string
+//   - multi_sz ([]string): array of strings
+//   - dword (uint32): number
+//   - dword_le ([DwordLE]): number
+//   - dword_be ([DwordBE]): number
+//   - qword (uint64): string of the decimal number, as JSON numbers
+//     cannot hold every 64-bit integer in many decoders
+//   - binary ([]byte): string of hexadecimal bytes
+//   - binary_sz ([BinaryString]): string of hexadecimal bytes
+//   - internal ([InternalBytes]): string of hexadecimal bytes, with the
+//     type identifier in an additional identifier member
+//   - deleted ([Deleted]): data is omitted
+func (v RegistryValue) MarshalJSON() ([]byte, error) {
+	j := jsonValue{Name: v.Name}
+	var data any
+	switch d := v.Data.(type) {
+	case Deleted:
+		j.Type = "deleted"
+	case string:
+		j.Type, data = "sz", d
+	case ExpandableString:
+		j.Type, data = "expand_sz", d
+	case Link:
+		j.Type, data = "link", d
+	case []string:
+		if d == nil {
+			d = []string{}
+		}
+		j.Type, data = "multi_sz", d
+	case uint32:
+		j.Type, data = "dword", d
+	case DwordLE:
+		j.Type, data = "dword_le", d
+	case DwordBE:
+		j.Type, data = "dword_be", d
+	case uint64:
+		j.Type, data = "qword", strconv.FormatUint(d, 10)
+	case []byte:
+		j.Type, data = "binary", hex.EncodeToString(d)
+	case BinaryString:
+		j.Type, data = "binary_sz", hex.EncodeToString(d)
+	case InternalBytes:
+		j.Type, data = "internal", hex.EncodeToString(d.Data)
+		j.Identifier = &d.Identifier
+	default:
+		return nil, fmt.Errorf("wine: value %q has unsupported data type %T", v.Name, d)
+	}
+
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		j.Data = raw
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements the [json.Unmarshaler] interface, decoding
+// the encoding described by [RegistryValue.MarshalJSON] into v.
+func (v *RegistryValue) UnmarshalJSON(b []byte) error {
+	var j jsonValue
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+
+	data, err := j.data()
+	if err != nil {
+		return fmt.Errorf("wine: value %q: %w", j.Name, err)
+	}
+	*v = RegistryValue{j.Name, data}
+	return nil
+}
+
+func (j *jsonValue) data() (RegistryData, error) {
+	if j.Type == "deleted" {
+		return Deleted{}, nil
+	}
+	if j.Data == nil {
+		return nil, fmt.Errorf("missing %s data", j.Type)
+	}
+
+	var (
+		s   string
+		n   uint32
+		err error
+	)
+	switch j.Type {
+	case "sz", "expand_sz", "link", "qword", "binary", "binary_sz", "internal":
+		err = json.Unmarshal(j.Data, &s)
+	case "dword", "dword_le", "dword_be":
+		err = json.Unmarshal(j.Data, &n)
+	case "multi_sz":
+		var ss []string
+		err = json.Unmarshal(j.Data, &ss)
+		return ss, err
+	default:
+		return nil, fmt.Errorf("unknown type %q", j.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch j.Type {
+	case "sz":
+		return s, nil
+	case "expand_sz":
+		return ExpandableString(s), nil
+	case "link":
+		return Link(s), nil
+	case "dword":
+		return n, nil
+	case "dword_le":
+		return DwordLE(n), nil
+	case "dword_be":
+		return DwordBE(n), nil
+	case "qword":
+		q, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return q, nil
+	}
+
+	bytes, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	switch j.Type {
+	case "binary_sz":
+		return BinaryString(bytes), nil
+	case "internal":
+		if j.Identifier == nil {
+			return nil, fmt.Errorf("missing internal identifier")
+		}
+		return InternalBytes{*j.Identifier, bytes}, nil
+	}
+	return bytes, nil
+}
@@ -0,0 +1,55 @@
+package wine
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRegistryJSON(t *testing.T) {
+	k := testdata()
+	k.arch = "win32"
+	k.Query("Foo").SetValue("Value O", uint64(1<<63))
+	k.Query("Foo").SetValue("Value P", Deleted{})
+	k.Query(`Foo\Quz`).Deleted = true
+	k.Query(`Foo\Bar`).SetClass("Shell")
+	k.Add("Quux").SetLink(`HKCU\Foo`)
+
+	b, err := json.Marshal(k)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var u RegistryKey
+	if err := json.Unmarshal(b, &u); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !u.Equal(k) || u.Arch() != "win32" {
+		t.Fatalf("data lost during encoding: %s", b)
+	}
+	if p := u.Query(`Foo\Bar\Baz`).Parent(); p == nil || p.Path() != `HKEY_CURRENT_USER\Foo\Bar` {
+		t.Fatalf("expected parents to be set, got %v", p)
+	}
+
+	b, err = json.Marshal(u.Query(`Foo\Bar\Baz`).Values)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(b) != `[{"name":"Value J","type":"dword_le","data":305419896},`+
+		`{"name":"Value K","type":"dword_be","data":305419896},`+
+		`{"name":"Value L","type":"binary","data":""},`+
+		`{"name":"Value M","type":"internal","identifier":255,"data":"de"}]` {
+		t.Errorf("unexpected encoding %s", b)
+	}
+
+	for _, data := range []string{
+		`{"name":"A","type":"dword"}`,
+		`{"name":"A","type":"dword","data":"1"}`,
+		`{"name":"A","type":"internal","data":"de"}`,
+		`{"name":"A","type":"foo","data":""}`,
+	} {
+		var v RegistryValue
+		if err := json.Unmarshal([]byte(data), &v); err == nil {
+			t.Errorf("expected error for %s", data)
+		}
+	}
+}
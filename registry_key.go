@@ -4,25 +4,50 @@ import (
 	"reflect"
 	"slices"
 	"strings"
+	"time"
 )
 
 // RegistryKey represents a relative, offline Wine registry key with its
 // known values and subkeys.
 //
+// Key and value names are matched case-insensitively as done by Windows,
+// but their original casing is kept when exported.
+//
 // It is not reccomended to iterate over the Subkeys field to modify it,
-// use [RegistryKey.Add] and [RegistryKey.Delete].
+// use [RegistryKey.Add] and [RegistryKey.Delete]. Subkeys are looked up
+// by their names using an index, which is rebuilt once the Subkeys
+// slice is changed.
+//
+// Symlinked registry keys always contain a value with the name
+// SymbolicLinkValue and an absolute registry path such as
+// '\Registry\Machine\Software\Classes\AppsId' encoded in UTF16LE. They are
+// not followed by [RegistryKey.Query]; see [RegistryKey.QueryFollow].
 //
-// Symlinked registry keys are unsupported. They always contain a subkey
-// with the name SymbolicLinkValue and an absolute registry path
-// such as '\Registry\Machine\Software\Classes\AppsId' encoded in UTF16LE.
+// A key with no values and subkeys is exported as an empty key. To
+// express the removal of keys and values, see the Deleted field and
+// the [Deleted] data type.
 type RegistryKey struct {
 	Name    string
 	Values  []RegistryValue
 	Subkeys []*RegistryKey
 
-	parent   *RegistryKey
-	modified Filetime
-	link     bool
+	// Deleted marks the key as removed, and is exported with the
+	// '[-Key]' deletion syntax when exporting for regedit, without its
+	// values and subkeys. Deleted keys are never written to Wine's
+	// registry files.
+	Deleted bool
+
+	parent     *RegistryKey
+	modified   Filetime
+	link       bool
+	class      string
+	arch       string   // #arch of the root key's registry file
+	directives []string // unknown directives, kept for exporting
+
+	// index maps the case-insensitive names of Subkeys to themselves,
+	// as they were when indexedKeys was set to Subkeys.
+	index       map[string]*RegistryKey
+	indexedKeys []*RegistryKey
 }
 
 // RegistryValue represents a known registry key's value pairs.
@@ -42,21 +67,58 @@ func NewRegistryKey(path string) *RegistryKey {
 		i = len(path) - 1
 	}
 
-	parent := RegistryKey{Name: path[:i]}
-	switch parent.Name {
-	case "HKLM":
-		parent.Name = "HKEY_LOCAL_MACHINE"
-	case "HKCU":
-		parent.Name = "HKEY_CURRENT_USER"
-	}
+	parent := RegistryKey{Name: rootName(path[:i])}
 	return parent.Add(path[i+1:])
 }
 
+// rootName returns the full name of the root key name, which may be
+// abbreviated such as HKLM.
+func rootName(name string) string {
+	switch strings.ToUpper(name) {
+	case "HKLM", "HKEY_LOCAL_MACHINE":
+		return "HKEY_LOCAL_MACHINE"
+	case "HKCU", "HKEY_CURRENT_USER":
+		return "HKEY_CURRENT_USER"
+	case "HKCR", "HKEY_CLASSES_ROOT":
+		return "HKEY_CLASSES_ROOT"
+	case "HKU", "HKEY_USERS":
+		return "HKEY_USERS"
+	case "HKCC", "HKEY_CURRENT_CONFIG":
+		return "HKEY_CURRENT_CONFIG"
+	}
+	return name
+}
+
+// isRootName reports whether name is the name of a root key, which may
+// be abbreviated as accepted by rootName.
+func isRootName(name string) bool {
+	switch rootName(name) {
+	case "HKEY_LOCAL_MACHINE", "HKEY_CURRENT_USER", "HKEY_CLASSES_ROOT",
+		"HKEY_USERS", "HKEY_CURRENT_CONFIG":
+		return true
+	}
+	return false
+}
+
+// EqualRegistryPath reports whether the absolute registry paths a and b
+// are of the same key, comparing them case-insensitively as Windows does
+// with abbreviated root keys such as HKLM expanded.
+func EqualRegistryPath(a, b string) bool {
+	return equalName(expandPath(a), expandPath(b))
+}
+
+// expandPath returns the absolute registry path with the full name of
+// its root key, which may be abbreviated.
+func expandPath(path string) string {
+	root, rel, _ := strings.Cut(path, `\`)
+	return joinPath(rootName(root), rel)
+}
+
 // GetValue finds the a registry value with the given name in k. If it is
 // not found, nil will be returned.
 func (k *RegistryKey) GetValue(name string) *RegistryValue {
 	for i, v := range k.Values {
-		if v.Name == name {
+		if equalName(v.Name, name) {
 			return &k.Values[i]
 		}
 	}
@@ -67,9 +129,15 @@ func (k *RegistryKey) GetValue(name string) *RegistryValue {
 // an empty string to specify the (Default) key. See [RegistryData] for
 // more information.
 //
-// If the named value already exists in k, only the data will be set, otherwise
-// a new value will be added to k with the given name and data.
+// If the named value already exists in k, only the data will be set and the
+// existing name's casing kept, otherwise a new value will be added to k with
+// the given name and data. k's modification time is updated.
 func (k *RegistryKey) SetValue(name string, data RegistryData) (ret *RegistryValue) {
+	k.touch()
+	return k.setValue(name, data)
+}
+
+func (k *RegistryKey) setValue(name string, data RegistryData) *RegistryValue {
 	if v := k.GetValue(name); v != nil {
 		v.Data = data
 		return v
@@ -79,10 +147,19 @@ func (k *RegistryKey) SetValue(name string, data RegistryData) (ret *RegistryVal
 }
 
 // DeleteValue deletes the named value from k and reports whether the
-// value was found and successfully deleted.
+// value was found and successfully deleted, in which case k's
+// modification time is updated.
 func (k *RegistryKey) DeleteValue(name string) bool {
+	if !k.deleteValue(name) {
+		return false
+	}
+	k.touch()
+	return true
+}
+
+func (k *RegistryKey) deleteValue(name string) bool {
 	for i, v := range k.Values {
-		if v.Name != name {
+		if !equalName(v.Name, name) {
 			continue
 		}
 		k.Values = append(k.Values[:i], k.Values[i+1:]...)
@@ -92,7 +169,8 @@ func (k *RegistryKey) DeleteValue(name string) bool {
 }
 
 // Add will find the registry key located at path, relative to k,
-// and creates any parent key if necesary.
+// and creates any parent key if necesary. The created keys and the
+// parent of the first created key have their modification time updated.
 func (k *RegistryKey) Add(path string) *RegistryKey {
 	return k.queryPath(path, true)
 }
@@ -104,7 +182,7 @@ func (k *RegistryKey) AddKey(a *RegistryKey) *RegistryKey {
 		return nil
 	}
 	new := k.Root().queryPath(a.relative(), true)
-	new.Values = a.Values
+	new.Values = cloneValues(a.Values)
 
 	for _, sk := range a.Subkeys {
 		new.AddKey(sk)
@@ -112,6 +190,61 @@ func (k *RegistryKey) AddKey(a *RegistryKey) *RegistryKey {
 	return new
 }
 
+// Modified returns the time k or its values were last modified, as stored
+// in Wine's registry files. It is zero if the time is not known. Keys
+// imported from regedit exports, which have no times, are modified by
+// the import.
+func (k *RegistryKey) Modified() Filetime {
+	return k.modified
+}
+
+// SetModified sets k's modification time.
+func (k *RegistryKey) SetModified(modified Filetime) {
+	k.modified = modified
+}
+
+// touch sets k's modification time to the current time.
+func (k *RegistryKey) touch() {
+	k.modified = FromTime(time.Now())
+}
+
+// ModifiedSince returns k and its subkeys that were modified after t,
+// in the order they are in k's tree.
+func (k *RegistryKey) ModifiedSince(t time.Time) []*RegistryKey {
+	var keys []*RegistryKey
+	k.modifiedSince(FromTime(t), &keys)
+	return keys
+}
+
+func (k *RegistryKey) modifiedSince(ft Filetime, keys *[]*RegistryKey) {
+	if k.modified > ft {
+		*keys = append(*keys, k)
+	}
+	for _, subkey := range k.Subkeys {
+		subkey.modifiedSince(ft, keys)
+	}
+}
+
+// Class returns k's class name, which is empty for most registry keys.
+func (k *RegistryKey) Class() string {
+	return k.class
+}
+
+// SetClass sets k's class name.
+func (k *RegistryKey) SetClass(class string) {
+	k.class = class
+}
+
+// Arch returns the architecture of the Wineprefix the registry file of
+// k's root key was written for, either "win32" or "win64". If k was not
+// parsed from Wine's registry files, "win64" is assumed.
+func (k *RegistryKey) Arch() string {
+	if arch := k.Root().arch; arch != "" {
+		return arch
+	}
+	return "win64"
+}
+
 // Parent returns k's parent registry key. The parent can be null
 // if k is a root registry key such as HKEY_CURRENT_USER.
 func (k *RegistryKey) Parent() *RegistryKey {
@@ -170,8 +303,13 @@ func (k *RegistryKey) Query(path string) *RegistryKey {
 
 // Delete removes the named registry key path relative to k. If deletion
 // was successful and the key was found in k, true will be returned, otherwise
-// false will be returned.
+// false will be returned. The modification time of the deleted key's parent
+// is updated.
 func (k *RegistryKey) Delete(path string) bool {
+	return k.delete(path, true)
+}
+
+func (k *RegistryKey) delete(path string, touch bool) bool {
 	query := k.Query(path)
 	if query == nil {
 		return false
@@ -181,70 +319,278 @@ func (k *RegistryKey) Delete(path string) bool {
 		return true
 	}
 
-	for i, subkey := range query.parent.Subkeys {
+	parent := query.parent
+	for i, subkey := range parent.Subkeys {
 		if subkey != query {
 			continue
 		}
-		query.parent.Subkeys = append(
-			query.parent.Subkeys[:i],
-			query.parent.Subkeys[i+1:]...)
+		indexed := parent.indexed()
+		parent.Subkeys = append(parent.Subkeys[:i], parent.Subkeys[i+1:]...)
+		if indexed {
+			delete(parent.index, foldName(query.Name))
+			parent.indexedKeys = parent.Subkeys
+		}
+		if touch {
+			parent.touch()
+		}
 		return true
 	}
 	panic("wine: subkey successfully traversed but is missing in parent")
 }
 
 func (k *RegistryKey) queryPath(path string, create bool) *RegistryKey {
+	return k.lookup(path, create, create)
+}
+
+// lookup finds the registry key at path relative to k, creating any
+// missing keys if create is set. If touch is set, the created keys and
+// the parent of the first created key have their modification time
+// updated.
+func (k *RegistryKey) lookup(path string, create, touch bool) *RegistryKey {
+	return k.lookupWith(path, create, touch, (*RegistryKey).subkey)
+}
+
+// lookupWith is like lookup, but finds each direct subkey with find.
+func (k *RegistryKey) lookupWith(path string, create, touch bool,
+	find func(k *RegistryKey, name string) *RegistryKey) *RegistryKey {
 	if path == "" {
 		return k
 	}
 
 	current := k
-segment:
 	for _, segment := range strings.Split(path, `\`) {
-		// Iterate backwards as the most recently added key would
-		// be last, useful in parsing.
-		for _, subkey := range slices.Backward(current.Subkeys) {
-			if subkey.Name == segment {
-				current = subkey
-				continue segment
-			}
+		if subkey := find(current, segment); subkey != nil {
+			current = subkey
+			continue
 		}
 		if !create {
 			return nil
 		}
-		current.Subkeys = append(current.Subkeys, &RegistryKey{
-			Name:   segment,
-			parent: current,
-		})
-		current = current.Subkeys[len(current.Subkeys)-1]
+		subkey := &RegistryKey{Name: segment, parent: current}
+		if touch {
+			current.touch()
+			subkey.modified = current.modified
+		}
+		indexed := current.indexed()
+		current.Subkeys = append(current.Subkeys, subkey)
+		if indexed {
+			current.index[foldName(segment)] = subkey
+			current.indexedKeys = current.Subkeys
+		}
+		current = subkey
 	}
 	return current
 }
 
+// Clone returns a deep copy of k and its subkeys, which can be modified
+// without affecting k. The copy has no parent, and is the root key of
+// its own tree.
+func (k *RegistryKey) Clone() *RegistryKey {
+	return k.clone(nil)
+}
+
+// clone returns a deep copy of k and its subkeys, with its parent
+// set to parent.
+func (k *RegistryKey) clone(parent *RegistryKey) *RegistryKey {
+	c := &RegistryKey{
+		Name:       k.Name,
+		Deleted:    k.Deleted,
+		parent:     parent,
+		modified:   k.modified,
+		link:       k.link,
+		class:      k.class,
+		arch:       k.arch,
+		directives: slices.Clone(k.directives),
+	}
+	c.Values = cloneValues(k.Values)
+	if k.Subkeys != nil {
+		c.Subkeys = make([]*RegistryKey, len(k.Subkeys))
+		for i, subkey := range k.Subkeys {
+			c.Subkeys[i] = subkey.clone(c)
+		}
+	}
+	return c
+}
+
+// cloneValues returns a copy of the values and their data, keeping
+// nil values as nil.
+func cloneValues(values []RegistryValue) []RegistryValue {
+	if values == nil {
+		return nil
+	}
+	c := make([]RegistryValue, len(values))
+	for i, v := range values {
+		c[i] = RegistryValue{v.Name, cloneData(v.Data)}
+	}
+	return c
+}
+
+// cloneData returns a copy of the data, such that modifying the
+// contents of the copy does not modify data.
+func cloneData(data RegistryData) RegistryData {
+	switch d := data.(type) {
+	case []byte:
+		return slices.Clone(d)
+	case BinaryString:
+		return slices.Clone(d)
+	case []string:
+		return slices.Clone(d)
+	case InternalBytes:
+		return InternalBytes{d.Identifier, slices.Clone(d.Data)}
+	}
+	return data
+}
+
+// minIndexed is the amount of subkeys a key must have for them to be
+// looked up using an index, rather than linearly.
+const minIndexed = 16
+
+// subkey returns the direct subkey of k with the given name.
+func (k *RegistryKey) subkey(name string) *RegistryKey {
+	if len(k.Subkeys) < minIndexed {
+		return k.linearSubkey(name)
+	}
+	if subkey, ok := k.indexedSubkey(name); subkey != nil || ok {
+		return subkey
+	}
+	// Subkeys may have been renamed or replaced within Subkeys
+	// since indexing, which the index would miss.
+	subkey := k.linearSubkey(name)
+	if subkey != nil {
+		k.reindex()
+	}
+	return subkey
+}
+
+// trustedSubkey is like subkey, but a subkey missing from the index is
+// taken to not exist. This is only correct if the subkeys of k were not
+// changed other than by lookup and delete since indexing, such as when
+// k was created by parsing.
+func (k *RegistryKey) trustedSubkey(name string) *RegistryKey {
+	if len(k.Subkeys) < minIndexed {
+		return k.linearSubkey(name)
+	}
+	subkey, _ := k.indexedSubkey(name)
+	return subkey
+}
+
+// indexedSubkey returns the direct subkey of k with the given name from
+// its index, reindexing k if needed. It reports whether k was reindexed,
+// in which case a missing subkey does not exist.
+func (k *RegistryKey) indexedSubkey(name string) (*RegistryKey, bool) {
+	if !k.indexed() {
+		k.reindex()
+		return k.index[foldName(name)], true
+	}
+	subkey := k.index[foldName(name)]
+	if subkey == nil || (subkey.parent == k && equalName(subkey.Name, name)) {
+		return subkey, false
+	}
+	// The subkey was renamed or moved since indexing
+	k.reindex()
+	return k.index[foldName(name)], true
+}
+
+// linearSubkey is like subkey, but does not use the subkey index.
+func (k *RegistryKey) linearSubkey(name string) *RegistryKey {
+	// Iterate backwards as the most recently added key would
+	// be last, useful in parsing.
+	for _, subkey := range slices.Backward(k.Subkeys) {
+		if equalName(subkey.Name, name) {
+			return subkey
+		}
+	}
+	return nil
+}
+
+// indexed reports whether k's subkey index is up to date, which is the
+// case if the Subkeys slice was not changed since it was indexed.
+func (k *RegistryKey) indexed() bool {
+	return k.index != nil && len(k.indexedKeys) == len(k.Subkeys) &&
+		(len(k.Subkeys) == 0 || &k.indexedKeys[0] == &k.Subkeys[0])
+}
+
+// reindex builds the index of k's subkeys by their case-insensitive
+// names. The most recently added key takes precedence for duplicate
+// names, as done when looking up subkeys linearly.
+func (k *RegistryKey) reindex() {
+	k.index = make(map[string]*RegistryKey, len(k.Subkeys))
+	for _, subkey := range k.Subkeys {
+		k.index[foldName(subkey.Name)] = subkey
+	}
+	k.indexedKeys = k.Subkeys
+}
+
+// Equal reports whether k and b, including their subkeys, have the same
+// names, values and metadata in the same order. A nil key is only equal
+// to another nil key.
+//
 // This is preferred over [reflect.DeepEqual] as there are private pointer
 // properties.
 func (k *RegistryKey) Equal(b *RegistryKey) bool {
+	return k.EqualWith(b, EqualOptions{})
+}
+
+// EqualOptions specify how registry keys are compared by
+// [RegistryKey.EqualWith].
+type EqualOptions struct {
+	// IgnoreModified ignores the modification times of the keys.
+	IgnoreModified bool
+
+	// IgnoreOrder compares the values and subkeys of the keys regardless
+	// of their order, matching them by their case-insensitive names.
+	IgnoreOrder bool
+
+	// IgnoreDwordLE treats uint32 and [DwordLE] data as equal, as both
+	// represent a REG_DWORD.
+	IgnoreDwordLE bool
+}
+
+// EqualWith is like [RegistryKey.Equal], comparing k and b with
+// the given options.
+func (k *RegistryKey) EqualWith(b *RegistryKey, opts EqualOptions) bool {
 	if k == nil || b == nil {
-		return true
+		return k == b
 	}
-	if k.Name != b.Name || k.modified != b.modified || k.link != b.link {
+	if k.Name != b.Name || k.Deleted != b.Deleted || k.link != b.link || k.class != b.class ||
+		(!opts.IgnoreModified && k.modified != b.modified) {
 		return false
 	}
-	if len(k.Values) != len(b.Values) {
+	if len(k.Values) != len(b.Values) || len(k.Subkeys) != len(b.Subkeys) {
 		return false
 	}
-	for i := range k.Values {
-		if !reflect.DeepEqual(k.Values[i], b.Values[i]) {
+
+	for i, v := range k.Values {
+		bv := &b.Values[i]
+		if opts.IgnoreOrder {
+			if bv = b.GetValue(v.Name); bv == nil {
+				return false
+			}
+		}
+		if v.Name != bv.Name || !equalData(v.Data, bv.Data, opts) {
 			return false
 		}
 	}
-	if len(k.Subkeys) != len(b.Subkeys) {
-		return false
-	}
-	for i := range k.Subkeys {
-		if !k.Subkeys[i].Equal(b.Subkeys[i]) {
+	for i, sk := range k.Subkeys {
+		bk := b.Subkeys[i]
+		if opts.IgnoreOrder {
+			bk = b.subkey(sk.Name)
+		}
+		if !sk.EqualWith(bk, opts) {
 			return false
 		}
 	}
 	return true
 }
+
+func equalData(a, b RegistryData, opts EqualOptions) bool {
+	if opts.IgnoreDwordLE {
+		if d, ok := a.(DwordLE); ok {
+			a = uint32(d)
+		}
+		if d, ok := b.(DwordLE); ok {
+			b = uint32(d)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
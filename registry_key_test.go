@@ -3,7 +3,10 @@ package wine
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestRegistryOperations(t *testing.T) {
@@ -14,6 +17,9 @@ func TestRegistryOperations(t *testing.T) {
 		if k == nil {
 			t.Fatal("expected key addition")
 		}
+		if k.Modified().IsZero() || root.Modified() != k.Modified() {
+			t.Fatalf("expected modification time update, got %v", k.Modified())
+		}
 		value := k.SetValue("Value A", uint32(0xdeadbeef))
 		data, ok := value.Data.(uint32)
 		if !ok {
@@ -29,7 +35,7 @@ func TestRegistryOperations(t *testing.T) {
 		if sub == nil {
 			t.Fatal("expected key query")
 		}
-		if !sub.Equal(&RegistryKey{
+		if !clearModified(sub).Equal(&RegistryKey{
 			Name:   "Baz",
 			Values: []RegistryValue{{"Value A", uint32(0xdeadbeef)}},
 		}) {
@@ -40,7 +46,10 @@ func TestRegistryOperations(t *testing.T) {
 	t.Run("edit value", func(t *testing.T) {
 		k := root.Query("Baz")
 		k.SetValue("Value A", uint64(0xdeadbeef))
-		if !k.Equal(&RegistryKey{
+		if k.Modified().IsZero() {
+			t.Fatal("expected modification time update")
+		}
+		if !clearModified(k).Equal(&RegistryKey{
 			Name:   "Baz",
 			Values: []RegistryValue{{"Value A", uint64(0xdeadbeef)}},
 		}) {
@@ -84,7 +93,7 @@ func TestRegistryOperations(t *testing.T) {
 		root := NewRegistryKey(`HKCU\`)
 		root.AddKey(new)
 
-		if !root.Equal(&RegistryKey{
+		if !clearModified(root).Equal(&RegistryKey{
 			Name: "HKEY_CURRENT_USER",
 			Subkeys: []*RegistryKey{{
 				Name:   "Quuz",
@@ -97,6 +106,56 @@ func TestRegistryOperations(t *testing.T) {
 		}) {
 			t.Fatalf("expected key match, got %v", root)
 		}
+
+		new.SetValue("Value A", uint64(0xcafebabe))
+		if v := root.Query("Quuz").GetValue("Value A"); v.Data != uint64(0xdeadbeef) {
+			t.Fatalf("expected added key values to be copied, got %v", v)
+		}
+	})
+
+	t.Run("case insensitive", func(t *testing.T) {
+		k := root.Query(`foo\BAR`)
+		if k == nil || k.Name != "Bar" {
+			t.Fatalf("expected key query, got %v", k)
+		}
+		if root.Add(`FOO`) != root.Query("Foo") {
+			t.Fatal("expected existing key addition")
+		}
+
+		k.SetValue("value f", uint64(0xcafebabe))
+		if v := k.GetValue("VALUE F"); v == nil || v.Name != "Value F" {
+			t.Fatalf("expected original value name, got %v", v)
+		}
+		if len(k.Values) != 4 {
+			t.Fatalf("expected no duplicate value, got %v", k.Values)
+		}
+		k.SetValue("Value F", uint64(0xdeadbeef))
+	})
+
+	t.Run("indexed", func(t *testing.T) {
+		k := root.Add("Quux")
+		for i := range minIndexed * 2 {
+			k.Add(fmt.Sprintf("Key %d", i))
+		}
+		if sk := k.Query("KEY 20"); sk == nil || sk.Name != "Key 20" {
+			t.Fatalf("expected indexed key query, got %v", sk)
+		}
+		if !k.Delete("key 20") || k.Query("Key 20") != nil {
+			t.Fatal("expected indexed key deletion")
+		}
+		k.Subkeys[0].Name = "Key A"
+		if k.Query("Key 0") != nil || k.Query("key a") != k.Subkeys[0] {
+			t.Fatal("expected renamed key to be reindexed")
+		}
+		k.Subkeys[1].Name = "Key B"
+		if k.Query("key b") != k.Subkeys[1] || k.Query("Key 1") != nil {
+			t.Fatal("expected renamed key to be found before reindexing")
+		}
+		k.Subkeys[2] = &RegistryKey{Name: "Key C"}
+		if k.Query("Key C") != k.Subkeys[2] || k.Query("Key 2") != nil {
+			t.Fatal("expected replaced key to be found")
+		}
+		root.Delete("Quux")
 	})
 
 	t.Run("path", func(t *testing.T) {
@@ -112,12 +171,134 @@ func TestRegistryOperations(t *testing.T) {
 		if k := root.Query("Baz"); k != nil {
 			t.Fatal("expected deleted key as nil")
 		}
-		if data := testdata(); !root.Equal(data) {
+		if root.Modified() <= testdata().Modified() {
+			t.Fatal("expected parent modification time update")
+		}
+		if data := testdata(); !clearModified(root).Equal(clearModified(data)) {
 			t.Errorf("expected %#+v\ngot %#+v", data, root)
 		}
 	})
 }
 
+func TestRegistryModifiedSince(t *testing.T) {
+	root := testdata()
+	start := time.Now()
+	if keys := root.ModifiedSince(start); len(keys) != 0 {
+		t.Fatalf("expected no modified keys, got %v", keys)
+	}
+
+	root.Query(`Foo\Bar`).SetValue("Value F", uint64(1))
+	root.Add(`Quux\Quuz`)
+	var paths []string
+	for _, k := range root.ModifiedSince(start) {
+		paths = append(paths, k.Path())
+	}
+	if !reflect.DeepEqual(paths, []string{
+		`HKEY_CURRENT_USER`,
+		`HKEY_CURRENT_USER\Foo\Bar`,
+		`HKEY_CURRENT_USER\Quux`,
+		`HKEY_CURRENT_USER\Quux\Quuz`,
+	}) {
+		t.Fatalf("unexpected modified keys %v", paths)
+	}
+
+	if keys := root.ModifiedSince(time.Now().Add(time.Second)); len(keys) != 0 {
+		t.Fatalf("expected no modified keys, got %v", keys)
+	}
+}
+
+func TestRegistryClone(t *testing.T) {
+	root := testdata()
+	clone := root.Query("Foo").Clone()
+	if clone.Parent() != nil {
+		t.Fatalf("expected detached clone, got parent %v", clone.Parent())
+	}
+	if !clone.Equal(root.Query("Foo")) {
+		t.Fatalf("expected clone to equal original, got %v", clone)
+	}
+	for k := range clone.All() {
+		for _, sk := range k.Subkeys {
+			if sk.Parent() != k {
+				t.Fatalf("expected %s to have parent %s", sk.Path(), k.Path())
+			}
+		}
+	}
+
+	clone.Query("Bar").Values[1].Data.([]string)[0] = `C:\Quux`
+	clone.Query("Bar").SetValue("Value F", uint64(1))
+	clone.Delete("Baz")
+	if data := testdata().Query("Foo"); !clearModified(root.Query("Foo")).
+		Equal(clearModified(data)) {
+		t.Errorf("expected original to be unchanged, got %v", root.Query("Foo"))
+	}
+}
+
+func TestRegistryEqual(t *testing.T) {
+	var nilKey *RegistryKey
+	if !nilKey.Equal(nil) {
+		t.Fatal("expected nil keys to be equal")
+	}
+	if nilKey.Equal(testdata()) || testdata().Equal(nil) {
+		t.Fatal("expected nil key to only equal nil")
+	}
+
+	a, b := testdata(), testdata()
+	b.touch()
+	if a.Equal(b) || !a.EqualWith(b, EqualOptions{IgnoreModified: true}) {
+		t.Fatal("expected modification time to only be ignored with option")
+	}
+
+	foo := b.Query("Foo")
+	foo.Subkeys[0], foo.Subkeys[2] = foo.Subkeys[2], foo.Subkeys[0]
+	foo.Values[0], foo.Values[3] = foo.Values[3], foo.Values[0]
+	if a.EqualWith(b, EqualOptions{IgnoreModified: true}) ||
+		!a.EqualWith(b, EqualOptions{IgnoreModified: true, IgnoreOrder: true}) {
+		t.Fatal("expected order to only be ignored with option")
+	}
+
+	b = testdata()
+	b.Query(`Foo\Bar\Baz`).Values[0].Data = uint32(0x12345678)
+	if a.Equal(b) || !a.EqualWith(b, EqualOptions{IgnoreDwordLE: true}) {
+		t.Fatal("expected DwordLE to only equal uint32 with option")
+	}
+	b.Query(`Foo\Bar\Baz`).Values[0].Data = uint32(0)
+	if a.EqualWith(b, EqualOptions{IgnoreDwordLE: true}) {
+		t.Fatal("expected differing dword data to be unequal")
+	}
+
+	b = testdata()
+	b.Query("Foo").Delete("Quz")
+	b.Query("Foo").Add("Quux")
+	if a.EqualWith(b, EqualOptions{IgnoreModified: true, IgnoreOrder: true}) {
+		t.Fatal("expected differing subkeys to be unequal")
+	}
+}
+
+func TestEqualRegistryPath(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want bool
+	}{
+		{`HKLM\Software\Foo`, `HKEY_LOCAL_MACHINE\SOFTWARE\foo`, true},
+		{`hkcu`, `HKEY_CURRENT_USER`, true},
+		{`HKLM\Software\Foo`, `HKLM\Software\Foo\Bar`, false},
+		{`HKLM\Software`, `HKCU\Software`, false},
+	} {
+		if got := EqualRegistryPath(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s, %s: expected equal %t, got %t", tt.a, tt.b, tt.want, got)
+		}
+	}
+}
+
+// clearModified zeroes the modification times of k and its subkeys.
+func clearModified(k *RegistryKey) *RegistryKey {
+	k.modified = 0
+	for _, sk := range k.Subkeys {
+		clearModified(sk)
+	}
+	return k
+}
+
 func testdata() *RegistryKey {
 	root := &RegistryKey{
 		Name:     "HKEY_CURRENT_USER",
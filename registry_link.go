@@ -0,0 +1,159 @@
+package wine
+
+import (
+	"strings"
+)
+
+// linkValue is the name of the value holding a symbolic link key's target.
+const linkValue = "SymbolicLinkValue"
+
+// maxLinkDepth is the maximum amount of links that will be followed
+// in a row, as to not loop forever on links pointing to each other.
+const maxLinkDepth = 16
+
+// IsLink reports whether k is a symbolic link to another registry key.
+func (k *RegistryKey) IsLink() bool {
+	return k.link
+}
+
+// LinkTarget returns the absolute path of the registry key that k links to,
+// as stored in k's SymbolicLinkValue, such as '\Registry\Machine\Software'.
+// If k is not a link, an empty string is returned.
+func (k *RegistryKey) LinkTarget() string {
+	if !k.link {
+		return ""
+	}
+	v := k.GetValue(linkValue)
+	if v == nil {
+		return ""
+	}
+	switch d := v.Data.(type) {
+	case Link:
+		return string(d)
+	case string:
+		return d
+	}
+	return ""
+}
+
+// SetLink marks k as a symbolic link to the target registry key.
+// The target may be either a Windows NT registry path such as
+// '\Registry\Machine\Software', or a path prefixed with a root
+// key such as 'HKLM\Software', which will be converted to the former.
+//
+// Wine expects link keys to have no other values or subkeys.
+func (k *RegistryKey) SetLink(target string) {
+	k.link = true
+	k.SetValue(linkValue, Link(ntPath(target)))
+}
+
+// QueryFollow is like [RegistryKey.Query], but follows any symbolic link
+// keys encountered. Only links to keys within k's tree can be followed;
+// see [Registry.QueryFollow] to follow links between root keys.
+func (k *RegistryKey) QueryFollow(path string) *RegistryKey {
+	return k.queryFollow(path, k.resolve)
+}
+
+func (k *RegistryKey) queryFollow(path string, resolve func(string) *RegistryKey) *RegistryKey {
+	current := k.follow(resolve)
+	if path == "" || current == nil {
+		return current
+	}
+
+	for _, segment := range strings.Split(path, `\`) {
+		current = current.queryPath(segment, false)
+		if current = current.follow(resolve); current == nil {
+			return nil
+		}
+	}
+	return current
+}
+
+// follow returns the registry key k links to with resolve, or k if it is
+// not a link. nil is returned if the link target could not be found.
+func (k *RegistryKey) follow(resolve func(string) *RegistryKey) *RegistryKey {
+	for i := 0; k != nil && k.link; i++ {
+		if i == maxLinkDepth {
+			return nil
+		}
+		target := k.LinkTarget()
+		if target == "" {
+			return nil
+		}
+		k = resolve(linkPath(target))
+	}
+	return k
+}
+
+// resolve finds the key at the given absolute path within k's tree.
+func (k *RegistryKey) resolve(path string) *RegistryKey {
+	return k.resolvePath(path, false)
+}
+
+func (k *RegistryKey) resolvePath(path string, create bool) *RegistryKey {
+	root := k.Root()
+	// Exported registry files have the root keys as subkeys
+	if root.Name == "" {
+		return root.queryPath(path, create)
+	}
+
+	rel, ok := cutPath(path, root.Name)
+	if !ok {
+		return nil
+	}
+	return root.queryPath(rel, create)
+}
+
+// cutPath returns path relative to parent if path is parent or
+// one of its subkeys.
+func cutPath(path, parent string) (string, bool) {
+	if len(path) < len(parent) || !equalName(path[:len(parent)], parent) {
+		return "", false
+	}
+	rel := path[len(parent):]
+	if rel == "" {
+		return "", true
+	}
+	if rel[0] != '\\' {
+		return "", false
+	}
+	return rel[1:], true
+}
+
+// ntPath converts the absolute registry path to the Windows NT registry
+// path used by symbolic link keys.
+func ntPath(path string) string {
+	if strings.HasPrefix(path, `\`) {
+		return path
+	}
+
+	root, key, _ := strings.Cut(path, `\`)
+	switch strings.ToUpper(root) {
+	case "HKEY_LOCAL_MACHINE", "HKLM":
+		root = `\Registry\Machine`
+	case "HKEY_CURRENT_USER", "HKCU":
+		root = `\Registry\User\` + sid
+	case "HKEY_USERS", "HKU":
+		root = `\Registry\User`
+	case "HKEY_CLASSES_ROOT", "HKCR":
+		root = `\Registry\Machine\Software\Classes`
+	case "HKEY_CURRENT_CONFIG", "HKCC":
+		root = `\Registry\Machine\System\CurrentControlSet\Hardware Profiles\Current`
+	}
+	return joinPath(root, key)
+}
+
+// linkPath converts the Windows NT registry path to an absolute registry
+// path prefixed with its root key name.
+func linkPath(target string) string {
+	if rel, ok := cutPath(target, `\Registry\Machine`); ok {
+		return joinPath("HKEY_LOCAL_MACHINE", rel)
+	}
+	if rel, ok := cutPath(target, `\Registry\User\`+sid); ok {
+		return joinPath("HKEY_CURRENT_USER", rel)
+	}
+	if rel, ok := cutPath(target, `\Registry\User`); ok {
+		return joinPath("HKEY_USERS", rel)
+	}
+	return target
+}
@@ -0,0 +1,75 @@
+package wine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistryLink(t *testing.T) {
+	root := &RegistryKey{Name: "HKEY_LOCAL_MACHINE"}
+	classes := root.Add(`Software\Classes\Wow6432Node\CLSID`)
+	classes.SetValue("Foo", "Bar")
+	root.Add(`Software\Wow6432Node\Classes`).SetLink(`HKLM\Software\Classes\Wow6432Node`)
+
+	link := root.Query(`Software\Wow6432Node\Classes`)
+	if !link.IsLink() {
+		t.Fatal("expected link key")
+	}
+	if target := link.LinkTarget(); target != `\Registry\Machine\Software\Classes\Wow6432Node` {
+		t.Fatalf("expected link target, got %s", target)
+	}
+
+	if k := root.Query(`Software\Wow6432Node\Classes\CLSID`); k != nil {
+		t.Fatalf("expected unfollowed query, got %v", k)
+	}
+	if k := root.QueryFollow(`software\wow6432node\classes\clsid`); k != classes {
+		t.Fatalf("expected followed query, got %v", k)
+	}
+
+	t.Run("cycle", func(t *testing.T) {
+		root.Add(`Software\Loop`).SetLink(`\Registry\Machine\Software\Loop`)
+		if k := root.QueryFollow(`Software\Loop\Foo`); k != nil {
+			t.Fatalf("expected unresolved query, got %v", k)
+		}
+		root.Delete(`Software\Loop`)
+	})
+
+	t.Run("registry", func(t *testing.T) {
+		reg := Registry{Machine: root}
+		reg.queryPath(`HKCU\Software\Classes`, true).SetLink(`HKLM\Software\Classes`)
+		if k := reg.QueryFollow(`HKCU\Software\Classes\Wow6432Node\CLSID`); k != classes {
+			t.Fatalf("expected followed query, got %v", k)
+		}
+		if k := reg.CurrentUser.QueryFollow(`Software\Classes\Wow6432Node`); k != nil {
+			t.Fatalf("expected unresolved query outside of tree, got %v", k)
+		}
+	})
+
+	t.Run("export", func(t *testing.T) {
+		root.Add(`Software\Classes\Wow6432Node\Parent`).SetLink(`HKLM\Software\Classes`)
+
+		buf := new(bytes.Buffer)
+		if err := root.Query("Software").Export(buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if x := buf.String(); x != linkExported {
+			t.Errorf("data unexportable")
+			t.Log(x)
+		}
+	})
+}
+
+const linkExported = `Windows Registry Editor Version 5.00
+
+[HKEY_LOCAL_MACHINE\Software\Classes\Wow6432Node\CLSID]
+"Foo"="Bar"
+
+[HKEY_LOCAL_MACHINE\Software\Classes\Wow6432Node\Parent\Wow6432Node\CLSID]
+"Foo"="Bar"
+
+[HKEY_LOCAL_MACHINE\Software\Wow6432Node\Classes\CLSID]
+"Foo"="Bar"
+
+[HKEY_LOCAL_MACHINE\Software\Wow6432Node\Classes\Parent\Wow6432Node\CLSID]
+"Foo"="Bar"
+`
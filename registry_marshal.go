@@ -0,0 +1,363 @@
+package wine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// registryTypes are the value types that can be used in a struct
+// field's reg tag, and the Go types they are marshalled as.
+var registryTypes = map[string]RegistryData{
+	"sz":        "",
+	"expand_sz": ExpandableString(""),
+	"multi_sz":  []string(nil),
+	"dword":     uint32(0),
+	"dword_be":  DwordBE(0),
+	"qword":     uint64(0),
+	"binary":    []byte(nil),
+	"link":      Link(""),
+}
+
+// MarshalRegistry returns a nameless registry key holding the exported
+// fields of the struct v as values, and its nested structs as subkeys.
+// The key's values and subkeys may then be added to another registry key.
+//
+// Each field can be customized with a 'reg' tag of the form
+// "Name,type,omitempty", where every part is optional:
+//   - Name is the name of the value or subkey, defaulting to the field's
+//     name. '@' is the (Default) value, and '-' omits the field.
+//   - type is one of sz, expand_sz, multi_sz, dword, dword_be, qword,
+//     binary or link, which are the REG_ types the field is converted to.
+//     Without it, the type is derived from the field: the [RegistryData]
+//     types are kept as is, booleans and integers of up to 32 bits are
+//     dwords, larger integers are qwords, and strings, string slices
+//     and byte slices are sz, multi_sz and binary respectively. Signed
+//     integers are stored as dwords in two's complement, and must fit
+//     within an int32.
+//   - omitempty omits the field if it has the zero value.
+//
+// Nested structs and pointers to structs are marshalled as subkeys, with
+// nil pointers being omitted. Embedded structs without a name in their
+// tag have their fields marshalled as if they were in the outer struct.
+func MarshalRegistry(v any) (*RegistryKey, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("wine: cannot marshal %T into registry key", v)
+	}
+
+	k := new(RegistryKey)
+	if err := marshalStruct(k, rv); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// UnmarshalRegistry sets the fields of the struct pointed to by v to the
+// values and subkeys in k, following the rules of [MarshalRegistry].
+// Fields with no corresponding value or subkey in k are left untouched.
+//
+// Values are converted to the field's type as done by [ValueAs], and
+// integers must fit within the field. Data that cannot be converted
+// will return an error describing the value and the field.
+func UnmarshalRegistry(k *RegistryKey, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("wine: cannot unmarshal registry key into %T", v)
+	}
+	return unmarshalStruct(k, rv.Elem())
+}
+
+// registryField is a parsed struct field and its reg tag.
+type registryField struct {
+	reflect.StructField
+	name      string
+	typ       string
+	omitEmpty bool
+	inline    bool
+}
+
+// fields returns the marshallable fields of the struct type t.
+func fields(t reflect.Type) ([]registryField, error) {
+	var fs []registryField
+	for i := 0; i < t.NumField(); i++ {
+		f := registryField{StructField: t.Field(i), name: t.Field(i).Name}
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(f.Tag.Get("reg"), ",")
+		switch name {
+		case "-":
+			continue
+		case "@":
+			f.name = ""
+		case "":
+			f.inline = f.Anonymous && indirect(f.Type).Kind() == reflect.Struct &&
+				(f.IsExported() || f.Type.Kind() != reflect.Pointer)
+		default:
+			f.name = name
+		}
+		if !f.IsExported() && !f.inline {
+			continue
+		}
+
+		for _, opt := range strings.Split(opts, ",") {
+			if _, ok := registryTypes[opt]; ok {
+				f.typ = opt
+				continue
+			}
+			switch opt {
+			case "":
+			case "omitempty":
+				f.omitEmpty = true
+			default:
+				return nil, fmt.Errorf("wine: field %s: unknown reg tag option %q", f.Name, opt)
+			}
+		}
+		if f.inline && f.typ != "" {
+			return nil, fmt.Errorf("wine: field %s: embedded struct cannot have a type", f.Name)
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+// isSubkey reports whether the field f is marshalled as a subkey.
+func (f *registryField) isSubkey() bool {
+	return f.typ == "" && indirect(f.Type).Kind() == reflect.Struct &&
+		!isRegistryData(f.Type)
+}
+
+// dword reports whether the field f is marshalled as a dword by its tag.
+func (f *registryField) dword() bool {
+	return f.typ == "dword" || f.typ == "dword_be"
+}
+
+func marshalStruct(k *RegistryKey, rv reflect.Value) error {
+	fs, err := fields(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fs {
+		fv := rv.FieldByIndex(f.Index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+
+		if f.inline || f.isSubkey() {
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			sk := k
+			if !f.inline {
+				sk = k.Add(f.name)
+			}
+			if err := marshalStruct(sk, fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := fieldData(fv, f.dword())
+		if err == nil && f.typ != "" {
+			data, err = convertData(data, registryTypes[f.typ])
+		}
+		if err != nil {
+			return fmt.Errorf("wine: field %s: %w", f.Name, err)
+		}
+		k.SetValue(f.name, data)
+	}
+	return nil
+}
+
+// isRegistryData reports whether t is one of the Go types of [RegistryData].
+func isRegistryData(t reflect.Type) bool {
+	switch reflect.Zero(t).Interface().(type) {
+	case string, ExpandableString, Link, BinaryString,
+		uint32, DwordLE, DwordBE, uint64,
+		[]string, []byte, InternalBytes:
+		return true
+	}
+	return false
+}
+
+// fieldData returns the registry data fv is marshalled as by default,
+// or as a dword if dword is set.
+func fieldData(fv reflect.Value, dword bool) (RegistryData, error) {
+	if isRegistryData(fv.Type()) {
+		return fv.Interface(), nil
+	}
+
+	switch fv.Kind() {
+	case reflect.Bool:
+		if fv.Bool() {
+			return uint32(1), nil
+		}
+		return uint32(0), nil
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return uint32(fv.Int()), nil
+	case reflect.Int, reflect.Int64:
+		if n := fv.Int(); dword {
+			// Signed dwords are read back as int32, so larger
+			// integers would not survive unmarshalling.
+			if n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, errOverflow
+			}
+			return uint32(n), nil
+		}
+		return uint64(fv.Int()), nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return uint32(fv.Uint()), nil
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return fv.Uint(), nil
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Slice:
+		switch fv.Type().Elem().Kind() {
+		case reflect.Uint8:
+			return fv.Bytes(), nil
+		case reflect.String:
+			s := make([]string, fv.Len())
+			for i := range s {
+				s[i] = fv.Index(i).String()
+			}
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported type %s", fv.Type())
+}
+
+func unmarshalStruct(k *RegistryKey, rv reflect.Value) error {
+	fs, err := fields(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fs {
+		fv := rv.FieldByIndex(f.Index)
+
+		if f.inline || f.isSubkey() {
+			sk := k
+			if !f.inline {
+				sk = k.subkey(f.name)
+			}
+			if sk == nil {
+				continue
+			}
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					fv.Set(reflect.New(fv.Type().Elem()))
+				}
+				fv = fv.Elem()
+			}
+			if err := unmarshalStruct(sk, fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		v := k.GetValue(f.name)
+		if v == nil {
+			continue
+		}
+		if err := setField(fv, v.Data, f.dword()); err != nil {
+			return fmt.Errorf("wine: %s: field %s: %w", valuePath(k, f.name), f.Name, err)
+		}
+	}
+	return nil
+}
+
+var errOverflow = errors.New("data overflows field")
+
+// setField sets fv to data, converted to the type of fv. If dword is
+// set, data is read back as a dword, like fields of up to 32 bits.
+func setField(fv reflect.Value, data RegistryData, dword bool) error {
+	if isRegistryData(fv.Type()) {
+		d, err := convertData(data, fv.Interface())
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(d))
+		return nil
+	}
+
+	var to RegistryData
+	switch fv.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		to = uint64(0)
+	case reflect.String:
+		to = ""
+	case reflect.Slice:
+		switch fv.Type().Elem().Kind() {
+		case reflect.Uint8:
+			to = []byte(nil)
+		case reflect.String:
+			to = []string(nil)
+		}
+	}
+	if to == nil {
+		return fmt.Errorf("unsupported type %s", fv.Type())
+	}
+	d, err := convertData(data, to)
+	if err != nil {
+		return err
+	}
+
+	switch d := d.(type) {
+	case uint64:
+		switch fv.Kind() {
+		case reflect.Bool:
+			fv.SetBool(d != 0)
+		case reflect.Int, reflect.Int64:
+			if !dword {
+				if fv.OverflowInt(int64(d)) {
+					return errOverflow
+				}
+				fv.SetInt(int64(d))
+				break
+			}
+			fallthrough
+		case reflect.Int8, reflect.Int16, reflect.Int32:
+			// Dwords hold the two's complement of negative integers
+			n := int64(int32(d))
+			if d > 0xffffffff || fv.OverflowInt(n) {
+				return errOverflow
+			}
+			fv.SetInt(n)
+		default:
+			if fv.OverflowUint(d) {
+				return errOverflow
+			}
+			fv.SetUint(d)
+		}
+	case string:
+		fv.SetString(d)
+	case []byte:
+		fv.SetBytes(d)
+	case []string:
+		s := reflect.MakeSlice(fv.Type(), len(d), len(d))
+		for i := range d {
+			s.Index(i).SetString(d[i])
+		}
+		fv.Set(s)
+	}
+	return nil
+}
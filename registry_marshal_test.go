@@ -0,0 +1,144 @@
+package wine
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type marshalDisplay struct {
+	LogPixels int  `reg:"LogPixels,dword"`
+	Managed   bool `reg:",omitempty"`
+}
+
+type marshalMeta struct {
+	Comment string `reg:"@"`
+}
+
+type marshalSettings struct {
+	marshalMeta
+	Version  string           `reg:"Version,sz"`
+	Path     string           `reg:"Path,expand_sz"`
+	Paths    []string         `reg:"Paths"`
+	Offset   int32            `reg:"Offset"`
+	Size     uint64           `reg:"Size"`
+	Blob     []byte           `reg:"Blob,omitempty"`
+	Mode     ExpandableString `reg:"Mode"`
+	Display  marshalDisplay   `reg:"Fonts"`
+	Audio    *marshalDisplay  `reg:"Audio"`
+	Ignored  string           `reg:"-"`
+	internal string
+}
+
+func TestRegistryMarshal(t *testing.T) {
+	s := marshalSettings{
+		marshalMeta: marshalMeta{"Settings"},
+		Version:     "win10",
+		Path:        `%SystemRoot%\Fonts`,
+		Paths:       []string{"a", "b"},
+		Offset:      -1,
+		Size:        1 << 40,
+		Mode:        "Foo",
+		Display:     marshalDisplay{LogPixels: 96},
+		Ignored:     "Ignored",
+		internal:    "internal",
+	}
+	k, err := MarshalRegistry(&s)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(k.Values, []RegistryValue{
+		{"", "Settings"},
+		{"Version", "win10"},
+		{"Path", ExpandableString(`%SystemRoot%\Fonts`)},
+		{"Paths", []string{"a", "b"}},
+		{"Offset", uint32(0xffffffff)},
+		{"Size", uint64(1 << 40)},
+		{"Mode", ExpandableString("Foo")},
+	}) {
+		t.Fatalf("unexpected values %#v", k.Values)
+	}
+	if len(k.Subkeys) != 1 || k.Subkeys[0].Name != "Fonts" ||
+		!reflect.DeepEqual(k.Subkeys[0].Values, []RegistryValue{{"LogPixels", uint32(96)}}) {
+		t.Fatalf("unexpected subkeys %#v", k.Subkeys)
+	}
+
+	k.Add("Audio").SetValue("Managed", DwordLE(1))
+	var u marshalSettings
+	if err := UnmarshalRegistry(k, &u); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.Ignored, s.internal = "", ""
+	s.Audio = &marshalDisplay{Managed: true}
+	if !reflect.DeepEqual(u, s) {
+		t.Fatalf("expected %#v, got %#v", s, u)
+	}
+
+	t.Run("dword", func(t *testing.T) {
+		type dwords struct {
+			A int   `reg:"A,dword"`
+			B int64 `reg:"B,dword_be"`
+		}
+		k, err := MarshalRegistry(dwords{-1, -2})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(k.Values, []RegistryValue{
+			{"A", uint32(0xffffffff)},
+			{"B", DwordBE(0xfffffffe)},
+		}) {
+			t.Fatalf("unexpected values %#v", k.Values)
+		}
+		var u dwords
+		if err := UnmarshalRegistry(k, &u); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if u.A != -1 || u.B != -2 {
+			t.Fatalf("expected negative integers, got %#v", u)
+		}
+		for _, n := range []int64{-1 << 32, math.MaxInt32 + 1, 3000000000} {
+			if _, err := MarshalRegistry(dwords{B: n}); err == nil {
+				t.Errorf("%d: expected overflow error", n)
+			}
+		}
+		k, err = MarshalRegistry(dwords{math.MaxInt32, math.MinInt32})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := UnmarshalRegistry(k, &u); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if u.A != math.MaxInt32 || u.B != math.MinInt32 {
+			t.Fatalf("expected int32 bounds, got %#v", u)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		k := new(RegistryKey)
+		k.SetValue("Offset", uint64(1<<32))
+		err := UnmarshalRegistry(k, &u)
+		if err == nil || !strings.Contains(err.Error(), `Offset: field Offset`) {
+			t.Errorf("expected overflow error, got %v", err)
+		}
+
+		k.SetValue("Offset", "Foo")
+		if err := UnmarshalRegistry(k, &u); err == nil {
+			t.Error("expected conversion error")
+		}
+		if err := UnmarshalRegistry(k, u); err == nil {
+			t.Error("expected non-pointer error")
+		}
+		if _, err := MarshalRegistry(struct {
+			A string `reg:"A,dword"`
+		}{"A"}); err == nil {
+			t.Error("expected type error")
+		}
+		if _, err := MarshalRegistry(struct {
+			A string `reg:"A,sz,bogus"`
+		}{}); err == nil {
+			t.Error("expected tag error")
+		}
+	})
+}
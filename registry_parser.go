@@ -10,9 +10,36 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf16"
+	"unicode/utf8"
 )
 
+// ParseError is returned when a registry file could not be parsed,
+// describing the line at which parsing failed.
+type ParseError struct {
+	File   string // name of the registry file, if known
+	Line   int    // line number, starting at 1
+	Column int    // column within Text, starting at 1, or 0 if unknown
+	Text   string // the line, with any continuation lines joined
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	pos := "line " + strconv.Itoa(e.Line)
+	if e.File != "" {
+		pos = e.File + ":" + strconv.Itoa(e.Line)
+	}
+	if e.Column > 0 {
+		pos += ":" + strconv.Itoa(e.Column)
+	}
+	return "wine: " + pos + ": " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 // ParseRegistryFile is a helper for ParseRegistry to parse from a registry file.
 func ParseRegistryFile(name string) (*RegistryKey, error) {
 	f, err := os.Open(name)
@@ -22,6 +49,10 @@ func ParseRegistryFile(name string) (*RegistryKey, error) {
 	defer f.Close()
 	var k RegistryKey
 	if err := k.Import(f); err != nil {
+		var perr *ParseError
+		if errors.As(err, &perr) {
+			perr.File = name
+		}
 		return nil, err
 	}
 	return &k, nil
@@ -31,126 +62,348 @@ func ParseRegistryFile(name string) (*RegistryKey, error) {
 // If parsing from Wine's internal .reg files, the root registry
 // will be named, but if parsing from a exported .reg file, the root registry key
 // will have no name.
+//
+// Exported registry files may be in either the 'Windows Registry Editor
+// Version 5.00' or the older 'REGEDIT4' format, whose strings are decoded
+// from the Windows-1252 ANSI code page unless they are valid UTF-8. Files
+// encoded in UTF-16LE with a byte order mark, as exported by Windows'
+// regedit, are decoded as well.
+//
+// Malformed registry files return a [*ParseError] describing the line
+// that could not be parsed.
 func (k *RegistryKey) Import(r io.Reader) error {
-	scanner := bufio.NewScanner(r)
-	scanner.Scan()
-	switch header := scanner.Text(); header {
-	case headerWine, headerExport:
-	default:
-		return fmt.Errorf("wine: expected registry header, got %s", header)
+	// The subkeys of k can only be looked up with their index alone if
+	// all of them are created by importing.
+	find := (*RegistryKey).subkey
+	if len(k.Subkeys) == 0 {
+		find = (*RegistryKey).trustedSubkey
 	}
 
 	var subkey *RegistryKey
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-
-		switch line[0] {
-		case ';':
-			if !strings.HasPrefix(line, ";; All keys relative to") {
-				continue
-			}
-			i := strings.LastIndexByte(line, ' ')
-			if i <= 0 {
-				return strconv.ErrSyntax
-			}
+	s := newRegistryScanner(r)
+	for s.scan() {
+		switch rec := s.rec; rec.kind {
+		case recordRoot:
 			if k.Name != "" {
-				return fmt.Errorf("wine: unexpected path directive")
+				return s.error(0, errors.New("unexpected path directive"))
 			}
-
-			switch path := line[i+1:]; path {
-			case `REGISTRY\\User\\` + sid:
-				k.Name = "HKEY_CURRENT_USER"
-			case `REGISTRY\\Machine`:
-				k.Name = "HKEY_LOCAL_MACHINE"
-			default:
-				return fmt.Errorf("wine: unknown registry path: %s", path)
+			k.Name = rec.path
+		case recordArch:
+			k.arch = rec.name
+		case recordKey:
+			subkey = k.lookupWith(rec.path, true, false, find)
+			if subkey == nil {
+				return s.error(0, errors.New("expected subkey traversal"))
 			}
-		case '#':
-			if !strings.HasPrefix(line, "#time=") {
-				if line == "#link" {
-					subkey.link = true
-				}
-				continue
+			// Exported registry files have no modification times,
+			// the keys are modified by importing them instead.
+			if rec.time != 0 {
+				subkey.modified = rec.time
+			} else if !s.wine {
+				subkey.touch()
 			}
+		case recordKeyDelete:
+			k.delete(rec.path, !s.wine)
+			subkey = nil
+		case recordTime:
+			subkey.modified = rec.time
+		case recordLink:
+			subkey.link = true
+		case recordClass:
+			subkey.class = rec.name
+		case recordDirective:
+			subkey.directives = append(subkey.directives, rec.name)
+		case recordValue:
+			subkey.setValue(rec.name, rec.data)
+		case recordValueDelete:
+			subkey.deleteValue(rec.name)
+		}
+	}
+	return s.err
+}
 
-			raw := line[strings.IndexByte(line, '=')+1:]
-			i, err := strconv.ParseInt(raw, 16, 64)
-			if err != nil {
-				return err
-			}
-			subkey.modified = Filetime(i)
-		case '[':
-			i := strings.IndexByte(line, ']')
-			if i <= 0 {
-				return strconv.ErrSyntax
-			}
+type recordKind int
 
-			path := Unescape(line[1:i])
-			if path, ok := strings.CutPrefix(path, "-"); ok {
-				k.Delete(path)
-				subkey = nil
-				continue
-			}
-			subkey = k.Add(path)
-			if subkey == nil {
-				return errors.New("expected subkey traversal")
-			}
-		case '"', '@':
-			if subkey == nil {
-				return errors.New("value without key")
-			}
-		bytescan:
-			if line[len(line)-1] == '\\' {
-				line = line[:len(line)-1]
-				// read ahead to obtain all multiline bytes, necessary
-				// to perform little/big endian serialization
-				for scanner.Scan() {
-					line += strings.TrimSpace(scanner.Text())
-					goto bytescan
-				}
-			}
+const (
+	recordRoot        recordKind = iota // ;; All keys relative to
+	recordArch                          // #arch=
+	recordKey                           // [Key] 1766588356
+	recordKeyDelete                     // [-Key]
+	recordTime                          // #time=
+	recordLink                          // #link
+	recordClass                         // #class=""
+	recordDirective                     // Any other key directive
+	recordValue                         // "Value"=
+	recordValueDelete                   // "Value"=-
+)
 
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) < 1 {
-				return strconv.ErrSyntax
-			}
-			name, raw := parts[0], parts[1]
+// registryRecord is a single entry of a registry file. Key records have the
+// path to the key as written in the file, which is relative to the root key
+// for Wine's registry files, and the modification time written after it.
+// All other records refer to the key most recently scanned, with the
+// value name, class or directive line stored in name.
+type registryRecord struct {
+	kind recordKind
+	path string
+	name string
+	data RegistryData
+	time Filetime
+}
 
-			switch name[0] {
-			case '@':
-				name = ""
-			case '"':
-				name = name[1 : len(name)-1]
-			}
+// registryScanner reads the records of a registry file, in either
+// Wine's or regedit's format.
+type registryScanner struct {
+	r    *bufio.Reader
+	wine bool   // Wine's registry format, which escapes key paths
+	ansi bool   // REGEDIT4 format, with ANSI strings
+	root string // root key name of Wine's registry files
+	key  bool   // whether a key was scanned, to allow values
+	rec  registryRecord
+	err  error
+
+	// line is the number of lines read, and start and text are the
+	// line number and text of the most recently parsed line.
+	line  int
+	start int
+	text  string
+
+	// filter reports whether the records of the key at the absolute
+	// path should be scanned. Records of other keys are skipped
+	// without parsing their values.
+	filter func(path string) bool
+	skip   bool
+}
+
+func newRegistryScanner(r io.Reader) *registryScanner {
+	br := bufio.NewReader(r)
+	r = br
+	// Windows' regedit exports in UTF-16LE, and other editors
+	// may write a UTF-8 byte order mark.
+	if bom, _ := br.Peek(3); bytes.HasPrefix(bom, []byte{0xFF, 0xFE}) {
+		_, _ = br.Discard(2)
+		r = &utf16Reader{r: br}
+	} else if bytes.Equal(bom, []byte{0xEF, 0xBB, 0xBF}) {
+		_, _ = br.Discard(3)
+	}
+
+	s := &registryScanner{r: br}
+	if r != br {
+		s.r = bufio.NewReader(r)
+	}
+	header, _ := s.readLine()
+	s.start, s.text = 1, header
+	switch header {
+	case headerWine:
+		s.wine = true
+	case headerExport:
+	case headerANSI:
+		s.ansi = true
+	default:
+		if s.err == nil {
+			s.err = s.error(1, errors.New("expected registry header"))
+		}
+	}
+	return s
+}
+
+// readLine reads the next line of any length, without its line ending,
+// and reports whether a line was read.
+func (s *registryScanner) readLine() (string, bool) {
+	line, err := s.r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		if err != io.EOF {
+			s.err = err
+		}
+		return "", false
+	}
+	s.line++
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	// REGEDIT4 files are written in the system's ANSI code page,
+	// but may have been written as UTF-8 by other editors.
+	if s.ansi && !utf8.ValidString(line) {
+		line = decodeANSI([]byte(line))
+	}
+	return line, true
+}
+
+// error returns a ParseError of err at the given column of the most
+// recently parsed line.
+func (s *registryScanner) error(col int, err error) error {
+	return &ParseError{Line: s.start, Column: col, Text: s.text, Err: err}
+}
+
+// scan advances to the next record, and reports whether one was found.
+func (s *registryScanner) scan() bool {
+	if s.err != nil {
+		return false
+	}
+	for {
+		line, ok := s.readLine()
+		if !ok {
+			return false
+		}
+		s.start, s.text = s.line, line
+		ok, err := s.parse(line)
+		if err != nil {
+			s.err = err
+			return false
+		}
+		if ok {
+			return true
+		}
+	}
+}
 
-			data, err := parseData(raw)
+// parse parses the line into the current record, and reports whether
+// the line was a record.
+func (s *registryScanner) parse(line string) (bool, error) {
+	if line == "" {
+		return false, nil
+	}
+
+	switch line[0] {
+	case ';':
+		if !strings.HasPrefix(line, ";; All keys relative to") {
+			return false, nil
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i <= 0 {
+			return false, s.error(0, strconv.ErrSyntax)
+		}
+
+		switch path := line[i+1:]; path {
+		case `REGISTRY\\User\\` + sid:
+			s.root = "HKEY_CURRENT_USER"
+		case `REGISTRY\\User\\.Default`:
+			s.root = `HKEY_USERS\.Default`
+		case `REGISTRY\\Machine`:
+			s.root = "HKEY_LOCAL_MACHINE"
+		default:
+			return false, s.error(i+2, fmt.Errorf("unknown registry path: %s", path))
+		}
+		s.rec = registryRecord{kind: recordRoot, path: s.root}
+	case '#':
+		if s.skip {
+			return false, nil
+		}
+		if !s.key {
+			arch, ok := strings.CutPrefix(line, "#arch=")
+			if !ok {
+				return false, nil
+			}
+			s.rec = registryRecord{kind: recordArch, name: arch}
+			return true, nil
+		}
+		if line == "#link" {
+			s.rec = registryRecord{kind: recordLink}
+			return true, nil
+		}
+		if class, ok := strings.CutPrefix(line, "#class="); ok {
+			name, err := unquote(class)
 			if err != nil {
-				return fmt.Errorf("parse %s: %w", name, err)
+				return false, s.error(len("#class=")+1, err)
 			}
-			if data == nil {
-				subkey.DeleteValue(name)
-				continue
+			s.rec = registryRecord{kind: recordClass, name: name}
+			return true, nil
+		}
+		raw, ok := strings.CutPrefix(line, "#time=")
+		if !ok {
+			s.rec = registryRecord{kind: recordDirective, name: line}
+			return true, nil
+		}
+
+		i, err := strconv.ParseInt(raw, 16, 64)
+		if err != nil {
+			return false, s.error(len("#time=")+1, err)
+		}
+		s.rec = registryRecord{kind: recordTime, time: Filetime(i)}
+	case '[':
+		// Regedit key paths are unescaped, and may contain ']'.
+		i := strings.LastIndexByte(line, ']')
+		if i <= 0 {
+			return false, s.error(len(line)+1, errors.New("expected ']'"))
+		}
+
+		path := line[1:i]
+		var modified Filetime
+		if s.wine {
+			path = Unescape(path)
+			// The modification time in seconds, superseded by #time
+			if stamp := strings.TrimSpace(line[i+1:]); stamp != "" {
+				unix, err := strconv.ParseInt(stamp, 10, 64)
+				if err != nil {
+					return false, s.error(i+strings.Index(line[i:], stamp)+1, err)
+				}
+				modified = FromTime(time.Unix(unix, 0))
 			}
+		}
+		path, deleted := strings.CutPrefix(path, "-")
+		s.key = !deleted
+		s.skip = s.filter != nil && !s.filter(joinPath(s.root, path))
+		if s.skip {
+			return false, nil
+		}
+		if deleted {
+			s.rec = registryRecord{kind: recordKeyDelete, path: path}
+			return true, nil
+		}
+		s.rec = registryRecord{kind: recordKey, path: path, time: modified}
+	case '"', '@':
+		if !s.key && !s.skip {
+			return false, s.error(0, errors.New("value without key"))
+		}
+		// read ahead to obtain all multiline bytes, necessary
+		// to perform little/big endian serialization
+		for strings.HasSuffix(line, "\\") {
+			next, ok := s.readLine()
+			if !ok {
+				break
+			}
+			line = line[:len(line)-1] + strings.TrimSpace(next)
+			s.text = line
+		}
+		if s.skip {
+			return false, nil
+		}
 
-			subkey.SetValue(name, data)
-		case '\n':
-			subkey = nil
+		name, raw, ok := cutValue(line)
+		if !ok {
+			return false, s.error(len(name)+1, errors.New("expected '='"))
+		}
+		switch {
+		case name == "@":
+			name = ""
+		case len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"':
+			name = name[1 : len(name)-1]
+		default:
+			return false, s.error(1, errors.New("expected quoted value name"))
 		}
-	}
 
-	return scanner.Err()
+		data, err := parseData(raw, s.ansi)
+		if err != nil {
+			return false, s.error(len(line)-len(raw)+1, fmt.Errorf("parse %s: %w", name, err))
+		}
+		s.rec = registryRecord{kind: recordValue, name: name, data: data}
+		if data == nil {
+			s.rec.kind = recordValueDelete
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
 }
 
-func parseData(value string) (RegistryData, error) {
+// parseData parses the value's data. If ansi is set, the hex(1), hex(2)
+// and hex(7) strings are decoded from the ANSI code page rather than
+// UTF16LE, as written in REGEDIT4 files.
+func parseData(value string, ansi bool) (RegistryData, error) {
 	if len(value) == 0 {
 		return nil, errors.New("expected data")
 	}
 	switch value[0] {
 	case '"':
-		return unquote(value), nil
+		return unquote(value)
 	case '-':
 		return nil, nil
 	}
@@ -168,10 +421,15 @@ func parseData(value string) (RegistryData, error) {
 		}
 		return uint32(v), nil
 	case "str(2)":
-		return ExpandableString(unquote(data)), nil
+		s, err := unquote(data)
+		return ExpandableString(s), err
 	case "str(7)":
-		s := strings.Split(unquote(data), "\x00")
-		return s[:len(s)-1], nil // foo\0bar\0 -> [foo, bar, ""]
+		s, err := unquote(data)
+		if err != nil {
+			return nil, err
+		}
+		v := strings.Split(s, "\x00")
+		return v[:len(v)-1], nil // foo\0bar\0 -> [foo, bar, ""]
 	}
 
 	if !strings.HasPrefix(value[:i], "hex") {
@@ -182,20 +440,35 @@ func parseData(value string) (RegistryData, error) {
 	if err != nil {
 		return nil, fmt.Errorf("hex: %w", err)
 	}
+	decode := decodeW
+	if ansi {
+		decode = func(b []byte) (string, error) {
+			return decodeANSI(b), nil
+		}
+	}
 	switch name := value[:i]; name {
 	case "hex", "hex(3)":
 		return hex, nil
 	case "hex(1)":
+		if ansi {
+			return BinaryString(encodeW(decodeANSI(hex) + "\x00")), nil
+		}
 		return BinaryString(hex), nil
 	case "hex(2)":
-		s, err := decodeW(hex)
+		s, err := decode(hex)
 		if err != nil {
 			return nil, err
 		}
 		return ExpandableString(s), nil
 	case "hex(4)":
+		if len(hex) < 4 {
+			return nil, errShortData
+		}
 		return DwordLE(binary.LittleEndian.Uint32(hex)), nil
 	case "hex(5)":
+		if len(hex) < 4 {
+			return nil, errShortData
+		}
 		return DwordBE(binary.BigEndian.Uint32(hex)), nil
 	case "hex(6)":
 		s, err := decodeW(hex)
@@ -204,17 +477,20 @@ func parseData(value string) (RegistryData, error) {
 		}
 		return Link(s), nil
 	case "hex(7)":
-		s, err := decodeW(hex)
+		s, err := decode(hex)
 		if err != nil {
 			return nil, err
 		}
 		v := strings.Split(s, "\x00")
 		return v[:len(v)-1], nil // foo\0bar\0 -> [foo, bar, ""]
 	case "hex(b)":
+		if len(hex) < 8 {
+			return nil, errShortData
+		}
 		return binary.LittleEndian.Uint64(hex), nil
 	default:
 		id := strings.IndexByte(name, '(')
-		if id <= 0 {
+		if id <= 0 || !strings.HasSuffix(name, ")") {
 			return nil, fmt.Errorf("unsupported hex type: %s", name)
 		}
 
@@ -256,13 +532,40 @@ func decodeW(b []byte) (string, error) {
 	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &ints); err != nil {
 		return "", err
 	}
-	if ints[len(ints)-1] == 0 {
+	if len(ints) > 0 && ints[len(ints)-1] == 0 {
 		// remove NULL terminator (if present)
 		ints = ints[:len(ints)-1]
 	}
 	return string(utf16.Decode(ints)), nil
 }
 
-func unquote(s string) string {
-	return Unescape(s[1 : len(s)-1])
+// errShortData is returned when parsing hex data that is too short
+// for its type.
+var errShortData = errors.New("data too short")
+
+// cutValue slices the value line around the '=' following the value
+// name, which may be quoted and contain '='.
+func cutValue(line string) (name, data string, ok bool) {
+	i := 0
+	if strings.HasPrefix(line, `"`) {
+		// Skip past the closing quote, ignoring escaped quotes
+		for i = 1; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' {
+				i++
+			}
+		}
+	}
+	j := strings.IndexByte(line[min(i, len(line)):], '=')
+	if j < 0 {
+		return line, "", false
+	}
+	return line[:i+j], line[i+j+1:], true
+}
+
+// unquote unescapes the quoted string s.
+func unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", errors.New("expected quoted string")
+	}
+	return Unescape(s[1 : len(s)-1]), nil
 }
@@ -1,8 +1,14 @@
 package wine
 
 import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestRegistryParse(t *testing.T) {
@@ -29,12 +35,17 @@ func TestRegistryImport(t *testing.T) {
 	var root RegistryKey
 	root.Add("HKEY_LOCAL_MACHINE").SetValue("Value A", 0xdeadbeef)
 	root.Add("HKEY_CURRENT_USER").SetValue("Value A", 0xdeadbeef)
+	clearModified(&root)
 
 	if err := root.Import(strings.NewReader(data)); err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
 
-	if !root.Equal(&RegistryKey{
+	// Keys imported from regedit files are modified by the import
+	if k := root.Query("HKEY_CURRENT_USER"); k == nil || k.Modified() == 0 {
+		t.Fatal("expected imported key to be modified")
+	}
+	if !clearModified(&root).Equal(&RegistryKey{
 		Name:    "",
 		Subkeys: []*RegistryKey{{Name: "HKEY_CURRENT_USER"}},
 	}) {
@@ -85,3 +96,173 @@ const userData = `WINE REGISTRY Version 2
 "SymbolicLinkValue"=hex(6):46,00,6f,00,6f,00,5c,00,42,00,61,00,72,00,5c,00,42,\
   00,61,00,7a,00
 `
+
+func TestRegistryImportCase(t *testing.T) {
+	const data = `Windows Registry Editor Version 5.00
+
+[HKEY_CURRENT_USER\Software\Quux]
+"Value A"=dword:00000001
+
+[hkey_current_user\SOFTWARE\QUUX]
+"value a"=dword:00000002
+"Value B"=-
+
+[-HKEY_CURRENT_USER\software\quux\Quz]
+`
+
+	var root RegistryKey
+	root.Add(`HKEY_CURRENT_USER\Software\Quux\Quz`)
+	root.Query(`HKEY_CURRENT_USER\Software\Quux`).SetValue("Value B", "")
+	clearModified(&root)
+
+	if err := root.Import(strings.NewReader(data)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if !clearModified(&root).Equal(&RegistryKey{
+		Subkeys: []*RegistryKey{{Name: "HKEY_CURRENT_USER", Subkeys: []*RegistryKey{{
+			Name: "Software", Subkeys: []*RegistryKey{{
+				Name:   "Quux",
+				Values: []RegistryValue{{"Value A", uint32(2)}},
+			}},
+		}}}},
+	}) {
+		t.Fatalf("expected case-insensitive import, got %s",
+			registryKeyJSON(&root))
+	}
+}
+
+func TestRegistryImportRawPath(t *testing.T) {
+	const data = `Windows Registry Editor Version 5.00
+
+[HKEY_CURRENT_USER\Software\foo\node[1]]
+"Value A"="\\server\"share\""
+`
+
+	var root RegistryKey
+	if err := root.Import(strings.NewReader(data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	k := root.Query(`HKEY_CURRENT_USER\Software\foo\node[1]`)
+	if k == nil {
+		t.Fatalf("expected unescaped key path, got %s", registryKeyJSON(&root))
+	}
+	if v := k.GetValue("Value A"); v == nil || v.Data != `\server"share"` {
+		t.Fatalf("expected escaped value, got %v", v)
+	}
+}
+
+func TestRegistryImportANSI(t *testing.T) {
+	const data = "REGEDIT4\r\n\r\n" +
+		"[HKEY_CURRENT_USER\\Software\\Foo]\r\n" +
+		"\"Value A\"=\"Caf\xe9 \x80\"\r\n" +
+		"\"Value B\"=\"Café\"\r\n" +
+		"\"Value C\"=hex(2):25,41,50,50,44,41,54,41,25,00\r\n" +
+		"\"Value D\"=hex(7):43,3a,5c,46,6f,6f,00,43,3a,5c,42,61,72,00,00\r\n" +
+		"\"Value E\"=hex(1):48,69,00\r\n"
+
+	var root RegistryKey
+	if err := root.Import(strings.NewReader(data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	k := root.Query(`HKEY_CURRENT_USER\Software\Foo`)
+	if k == nil {
+		t.Fatalf("expected key, got %s", registryKeyJSON(&root))
+	}
+	if !reflect.DeepEqual(k.Values, []RegistryValue{
+		{"Value A", "Café €"},
+		{"Value B", "Café"},
+		{"Value C", ExpandableString("%APPDATA%")},
+		{"Value D", []string{`C:\Foo`, `C:\Bar`}},
+		{"Value E", BinaryString{0x48, 0x0, 0x69, 0x0, 0x0, 0x0}},
+	}) {
+		t.Fatalf("unexpected values %#v", k.Values)
+	}
+}
+
+func TestRegistryImportUTF16(t *testing.T) {
+	var want RegistryKey
+	if err := want.Import(strings.NewReader(userExported)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Include a character outside of the BMP, encoded as surrogates
+	data := strings.Replace(userExported, `"Value A"`, `"Value 😀"`, 1)
+	want.Query(`HKEY_CURRENT_USER`).Values[1].Name = "Value 😀"
+
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{0xFF, 0xFE})
+	buf.Write(encodeW(strings.ReplaceAll(data, "\n", "\r\n")))
+
+	var root RegistryKey
+	if err := root.Import(iotest.OneByteReader(buf)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !clearModified(&root).Equal(clearModified(&want)) {
+		t.Fatalf("expected UTF-16 import, got %s", registryKeyJSON(&root))
+	}
+}
+
+func TestRegistryImportLongLine(t *testing.T) {
+	data := bytes.Repeat([]byte{0xde, 0xad}, 64*1024)
+	k := NewRegistryKey(`HKCU\Foo`)
+	k.SetValue("Value A", data)
+	k.SetValue("Value B", strings.Repeat("Foo", 64*1024))
+	buf := new(bytes.Buffer)
+	if err := k.Export(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var root RegistryKey
+	if err := root.Import(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !clearModified(root.Query(`HKEY_CURRENT_USER\Foo`)).
+		EqualWith(clearModified(k), EqualOptions{IgnoreModified: true}) {
+		t.Fatal("expected long values to be imported")
+	}
+}
+
+func TestRegistryImportErrors(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		err  ParseError
+	}{
+		{"REGEDIT5\n", ParseError{Line: 1, Column: 1, Text: "REGEDIT5"}},
+		{"REGEDIT4\n\n\"A\"=\"B\"\n", ParseError{Line: 3, Text: `"A"="B"`}},
+		{"REGEDIT4\n[HKCU\n", ParseError{Line: 2, Column: 6, Text: `[HKCU`}},
+		{"REGEDIT4\n[HKCU]\n\"A\"\n", ParseError{Line: 3, Column: 4, Text: `"A"`}},
+		{"REGEDIT4\n[HKCU]\n\"=1\n", ParseError{Line: 3, Column: 4, Text: `"=1`}},
+		{"REGEDIT4\n[HKCU]\n@=\"\n", ParseError{Line: 3, Column: 3, Text: `@="`}},
+		{"REGEDIT4\n[HKCU]\n@=hex(4):01,\\\n  02\n", ParseError{Line: 3, Column: 3, Text: `@=hex(4):01,02`}},
+		{"REGEDIT4\n[HKCU]\n@=hex(7):\n", ParseError{}},
+		{"REGEDIT4\n[HKCU]\n@=hex(:00\n", ParseError{Line: 3, Column: 3, Text: `@=hex(:00`}},
+	} {
+		var k RegistryKey
+		err := k.Import(strings.NewReader(tt.data))
+		var perr *ParseError
+		if tt.err.Line == 0 {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %v", tt.data, err)
+			}
+			continue
+		}
+		if !errors.As(err, &perr) {
+			t.Errorf("%q: expected parse error, got %v", tt.data, err)
+			continue
+		}
+		perr.Err = nil
+		if *perr != tt.err {
+			t.Errorf("%q: expected %#v, got %#v", tt.data, tt.err, *perr)
+		}
+	}
+
+	name := filepath.Join(t.TempDir(), "user.reg")
+	if err := os.WriteFile(name, []byte(headerWine+"\n#time=foo\n[Foo] bar\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ParseRegistryFile(name)
+	if want := "wine: " + name + ":3:7: "; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("expected error prefixed with %q, got %v", want, err)
+	}
+}
@@ -0,0 +1,133 @@
+package wine
+
+import (
+	"io"
+	"iter"
+)
+
+// RegistryRecord is a registry key or value read by a [RegistryScanner].
+type RegistryRecord struct {
+	// Path is the absolute path of the key, or the key of the value.
+	// For Wine's registry files, it is prefixed with the file's
+	// root key name, such as HKEY_LOCAL_MACHINE.
+	Path string
+
+	// Value is the value read, and is nil for key records. Removed
+	// values have [Deleted] data.
+	Value *RegistryValue
+
+	// Deleted reports whether the key or value is removed, such
+	// as with the '[-Key]' and '"Value"=-' deletion syntax.
+	Deleted bool
+
+	// Modified, Class and Link are the key's modification time, class
+	// name and whether it is a symbolic link, as written in Wine's
+	// registry files. They are unset for value records.
+	Modified Filetime
+	Class    string
+	Link     bool
+}
+
+// RegistryScanner reads the keys and values of a registry file in either
+// of the formats accepted by [RegistryKey.Import], one record at a time,
+// without keeping previously read records in memory.
+//
+// Each key is followed by records of its values, in the order they
+// appear in the registry file.
+type RegistryScanner struct {
+	s       *registryScanner
+	rec     RegistryRecord
+	key     string
+	pending bool // whether s.rec has not yet been handled
+}
+
+// NewRegistryScanner returns a RegistryScanner reading from r. If prefix
+// is set, only the records of the key at the absolute registry path
+// prefix and its subkeys will be read, with the values of other keys
+// skipped without being parsed.
+func NewRegistryScanner(r io.Reader, prefix string) *RegistryScanner {
+	s := &RegistryScanner{s: newRegistryScanner(r)}
+	if prefix == "" {
+		return s
+	}
+
+	prefix = expandPath(prefix)
+	s.s.filter = func(path string) bool {
+		_, ok := cutPath(path, prefix)
+		return ok
+	}
+	return s
+}
+
+// Scan advances the scanner to the next record, which will be available
+// from [RegistryScanner.Record]. It returns false when scanning stops,
+// either by reaching the end of input or an error.
+func (s *RegistryScanner) Scan() bool {
+	for s.pending || s.s.scan() {
+		s.pending = false
+		switch rec := s.s.rec; rec.kind {
+		case recordKey, recordKeyDelete:
+			s.key = joinPath(s.s.root, rec.path)
+			s.rec = RegistryRecord{
+				Path:     s.key,
+				Deleted:  rec.kind == recordKeyDelete,
+				Modified: rec.time,
+			}
+			s.directives()
+			return true
+		case recordValue, recordValueDelete:
+			s.rec = RegistryRecord{
+				Path:    s.key,
+				Value:   &RegistryValue{rec.name, rec.data},
+				Deleted: rec.kind == recordValueDelete,
+			}
+			if s.rec.Deleted {
+				s.rec.Value.Data = Deleted{}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// directives reads the key directives following the current key record,
+// up to the next record.
+func (s *RegistryScanner) directives() {
+	for s.s.scan() {
+		switch rec := s.s.rec; rec.kind {
+		case recordTime:
+			s.rec.Modified = rec.time
+		case recordClass:
+			s.rec.Class = rec.name
+		case recordLink:
+			s.rec.Link = true
+		case recordDirective:
+		default:
+			s.pending = true
+			return
+		}
+	}
+}
+
+// Record returns the most recent record read by [RegistryScanner.Scan].
+func (s *RegistryScanner) Record() RegistryRecord {
+	return s.rec
+}
+
+// Err returns the first error that was encountered by the scanner.
+func (s *RegistryScanner) Err() error {
+	return s.s.err
+}
+
+// Records returns an iterator over the remaining records of s, which
+// stops early if the loop is broken out of. [RegistryScanner.Err]
+// should be checked once the iteration is done.
+func (s *RegistryScanner) Records() iter.Seq[RegistryRecord] {
+	return func(yield func(RegistryRecord) bool) {
+		for s.Scan() {
+			if !yield(s.rec) {
+				return
+			}
+		}
+	}
+}
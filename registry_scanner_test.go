@@ -0,0 +1,67 @@
+package wine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegistryScanner(t *testing.T) {
+	s := NewRegistryScanner(strings.NewReader(userData), `hkcu\foo\bar`)
+	var paths []string
+	for r := range s.Records() {
+		if r.Value == nil {
+			paths = append(paths, r.Path)
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(paths, []string{
+		`HKEY_CURRENT_USER\Foo\Bar`,
+		`HKEY_CURRENT_USER\Foo\Bar\Baz`,
+	}) {
+		t.Fatalf("unexpected keys %v", paths)
+	}
+
+	s = NewRegistryScanner(strings.NewReader(userData), `HKEY_CURRENT_USER\Foo\Baz`)
+	var recs []RegistryRecord
+	for r := range s.Records() {
+		recs = append(recs, r)
+	}
+	if !reflect.DeepEqual(recs, []RegistryRecord{
+		{Path: `HKEY_CURRENT_USER\Foo\Baz`, Modified: Filetime(0x1dc74efdcc0807c), Link: true},
+		{Path: `HKEY_CURRENT_USER\Foo\Baz`, Value: &RegistryValue{linkValue, Link(`Foo\Bar\Baz`)}},
+	}) {
+		t.Fatalf("unexpected records %#v", recs)
+	}
+
+	s = NewRegistryScanner(strings.NewReader(directivesData), "")
+	if !s.Scan() || s.Record().Class != `Multifunction "Adapter"` {
+		t.Fatalf("expected key with class, got %#v", s.Record())
+	}
+	if !s.Scan() || s.Record().Value == nil || s.Record().Value.Name != "Identifier" {
+		t.Fatalf("expected value, got %#v", s.Record())
+	}
+
+	t.Run("regedit", func(t *testing.T) {
+		s := NewRegistryScanner(strings.NewReader(diffExported), `HKCU\Foo`)
+		for r := range s.Records() {
+			if r.Value != nil && r.Value.Name == "Value B" {
+				if !r.Deleted {
+					t.Fatalf("expected value deletion, got %#v", r)
+				}
+				break
+			}
+		}
+		if !s.Scan() || s.Record().Value == nil || s.Record().Value.Name != "Value C" {
+			t.Fatalf("expected scanning to resume after break, got %#v", s.Record())
+		}
+		if !s.Scan() || !s.Record().Deleted || s.Record().Path != `HKEY_CURRENT_USER\Foo\Bar` {
+			t.Fatalf("expected key deletion, got %#v", s.Record())
+		}
+		if s.Scan() {
+			t.Fatalf("unexpected record %#v", s.Record())
+		}
+	})
+}
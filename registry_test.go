@@ -2,9 +2,14 @@ package wine
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestRegistry(t *testing.T) {
@@ -72,14 +77,14 @@ func equalRegistry(t *testing.T, reg, exp *Registry) {
 
 	buf := bytes.Buffer{}
 
-	_ = reg.Machine.exportSystem(&buf)
+	_ = reg.Machine.exportSystem(&buf, ExportOptions{})
 	if b := buf.Bytes(); !bytes.Equal(b, []byte(registrySystemData)) {
 		t.Log(string(b))
 		t.Fatalf("expected machine key export match")
 	}
 
 	buf.Reset()
-	_ = reg.CurrentUser.exportSystem(&buf)
+	_ = reg.CurrentUser.exportSystem(&buf, ExportOptions{})
 	if b := buf.Bytes(); !bytes.Equal(b, []byte(registryUserData)) {
 		t.Log(string(b))
 		t.Fatalf("expected user key export match")
@@ -105,3 +110,293 @@ const registryUserData = `WINE REGISTRY Version 2
 #time=1dc3e01c855469c
 "Foo"="Bar"
 `
+
+func TestRegistryViews(t *testing.T) {
+	dir := t.TempDir()
+	pfx := New(dir, "")
+
+	for name, data := range map[string]string{
+		"system.reg":  registryViewsData,
+		"user.reg":    registryUserData,
+		"userdef.reg": registryDefaultUserData,
+	} {
+		if err := os.WriteFile(filepath.Join(pfx.dir, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("unexpected %s write error: %v", name, err)
+		}
+	}
+
+	reg, err := pfx.Registry()
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if reg.DefaultUser == nil || reg.DefaultUser.Name != `HKEY_USERS\.Default` {
+		t.Fatalf("expected default user key, got %v", reg.DefaultUser)
+	}
+
+	for _, tt := range []struct {
+		path string
+		want *RegistryKey
+	}{
+		{`HKLM\Software\Classes\.txt`, reg.Machine.Query(`Software\Classes\.txt`)},
+		{`HKU\.Default\Software\Foobar`, reg.DefaultUser.Query(`Software\Foobar`)},
+		{`HKEY_USERS\` + sid + `\Software\Foobar`, reg.CurrentUser.Query(`Software\Foobar`)},
+		{`HKCR\.txt`, reg.Machine.Query(`Software\Classes\.txt`)},
+		{`HKEY_CLASSES_ROOT`, reg.Machine.Query(`Software\Classes`)},
+		{`HKCC\Software`, reg.Machine.Query(`System\ControlSet001\Hardware Profiles\Current\Software`)},
+		{`HKEY_USERS\S-1-5-18`, nil},
+		{`HKEY_USERS`, nil},
+	} {
+		if got := reg.Query(tt.path); got != tt.want {
+			t.Errorf("expected %s to resolve to %v, got %v", tt.path, tt.want, got)
+		}
+	}
+
+	if k := reg.Query(`HKCR\.txt`); k == nil || k.GetValue("") == nil {
+		t.Fatalf("expected class key query, got %v", k)
+	}
+
+	k := reg.queryPath(`HKCR\.exe`, true)
+	if k == nil || k.Path() != `HKEY_LOCAL_MACHINE\Software\Classes\.exe` {
+		t.Fatalf("expected view key creation, got %v", k)
+	}
+	reg.Machine.Delete(`Software\Classes\.exe`)
+
+	if err := reg.Save(); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(pfx.dir, "userdef.reg"))
+	if err != nil {
+		t.Fatalf("unexpected default user read error: %v", err)
+	}
+	if string(b) != registryDefaultUserData {
+		t.Log(string(b))
+		t.Fatal("expected default user key export match")
+	}
+}
+
+const registryViewsData = `WINE REGISTRY Version 2
+;; All keys relative to REGISTRY\\Machine
+
+#arch=win64
+
+[Software\\Classes\\.txt] 1760553029
+#time=1dc3e01c855469c
+@="txtfile"
+
+[System\\ControlSet001\\Hardware Profiles\\Current\\Software] 1760553029
+#time=1dc3e01c855469c
+`
+
+const registryDefaultUserData = `WINE REGISTRY Version 2
+;; All keys relative to REGISTRY\\User\\.Default
+
+#arch=win64
+
+[Software\\Foobar] 1760553029
+#time=1dc3e01c855469c
+"Foo"="Bar"
+`
+
+func TestRegistrySave(t *testing.T) {
+	dir := t.TempDir()
+	pfx := New(dir, "")
+
+	if err := os.WriteFile(filepath.Join(pfx.dir, "system.reg"), []byte(registrySystemData), 0o600); err != nil {
+		t.Fatalf("unexpected system write error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(pfx.dir, "user.reg"), []byte(registryUserData), 0o644); err != nil {
+		t.Fatalf("unexpected user write error: %v", err)
+	}
+
+	reg, err := pfx.Registry()
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	reg.Query(`HKLM\Software\Foobar`).SetValue("Foo", "Baz")
+
+	if err := reg.SaveWith(SaveOptions{Backup: true}); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(pfx.dir, "system.reg.bak"))
+	if err != nil {
+		t.Fatalf("unexpected backup read error: %v", err)
+	}
+	if string(b) != registrySystemData {
+		t.Fatalf("expected backup of previous registry, got %s", b)
+	}
+
+	fi, err := os.Stat(filepath.Join(pfx.dir, "system.reg"))
+	if err != nil {
+		t.Fatalf("unexpected stat error: %v", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("expected file mode kept, got %v", perm)
+	}
+
+	entries, err := os.ReadDir(pfx.dir)
+	if err != nil {
+		t.Fatalf("unexpected read dir error: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("expected no leftover temporary files, got %v", entries)
+	}
+
+	reg, err = pfx.Registry()
+	if err != nil {
+		t.Fatalf("unexpected reread error: %v", err)
+	}
+	if v := reg.Query(`HKLM\Software\Foobar`).GetValue("Foo"); v == nil || v.Data != "Baz" {
+		t.Fatalf("expected saved value, got %v", v)
+	}
+
+	t.Run("running", func(t *testing.T) {
+		fi, err := os.Stat(pfx.dir)
+		if err != nil {
+			t.Fatalf("unexpected stat error: %v", err)
+		}
+		stat := fi.Sys().(*syscall.Stat_t)
+		server := filepath.Join(os.TempDir(), fmt.Sprintf(".wine-%d/server-%x-%x",
+			os.Getuid(), stat.Dev, stat.Ino))
+		if err := os.MkdirAll(server, 0o700); err != nil {
+			t.Fatalf("unexpected server dir error: %v", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(server) })
+		if err := os.WriteFile(filepath.Join(server, "socket"), nil, 0o600); err != nil {
+			t.Fatalf("unexpected socket write error: %v", err)
+		}
+
+		if err := reg.Save(); !errors.Is(err, ErrPrefixRunning) {
+			t.Fatalf("expected running error, got %v", err)
+		}
+	})
+}
+
+func TestRegistryCache(t *testing.T) {
+	dir := t.TempDir()
+	pfx := New(dir, "")
+	user := filepath.Join(dir, "user.reg")
+
+	if err := os.WriteFile(filepath.Join(dir, "system.reg"), []byte(registrySystemData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(user, []byte(registryUserData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reg, err := pfx.Registry()
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	reg.Query(`HKCU\Software\Foobar`).SetValue("Foo", "Baz")
+
+	cached, err := pfx.Registry()
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if cached.CurrentUser == reg.CurrentUser {
+		t.Fatal("expected a copy of the cached registry")
+	}
+	if v := cached.Query(`HKCU\Software\Foobar`).GetValue("Foo"); v.Data != "Bar" {
+		t.Fatalf("expected cached registry to be unmodified, got %v", v.Data)
+	}
+	if k := cached.Query(`HKCU\Software`); k.Parent() != cached.CurrentUser {
+		t.Fatal("expected copied parents")
+	}
+
+	// Replace the file within the same modification time and size.
+	data := strings.Replace(registryUserData, `"Foo"="Bar"`, `"Foo"="Baz"`, 1)
+	if err := os.WriteFile(user+".tmp", []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(user+".tmp", user); err != nil {
+		t.Fatal(err)
+	}
+	reg, err = pfx.Registry()
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if v := reg.Query(`HKCU\Software\Foobar`).GetValue("Foo"); v.Data != "Baz" {
+		t.Fatalf("expected registry to be reparsed, got %v", v.Data)
+	}
+}
+
+// benchmarkPrefix returns a Wineprefix with a registry of a size similar
+// to that of a Wineprefix with several programs installed.
+func benchmarkPrefix(b *testing.B) *Prefix {
+	b.Helper()
+	pfx := New(b.TempDir(), "")
+
+	machine := &RegistryKey{Name: "HKEY_LOCAL_MACHINE", modified: FromTime(time.Now())}
+	classes := machine.Add(`Software\Classes`)
+	for i := range 4000 {
+		ext := classes.Add(fmt.Sprintf(".ext%d", i))
+		ext.SetValue("", fmt.Sprintf("extfile%d", i))
+		ext.SetValue("Content Type", "application/octet-stream")
+	}
+	for i := range 12000 {
+		clsid := classes.Add(fmt.Sprintf(`CLSID\{%08X-0000-0000-C000-000000000046}`, i))
+		clsid.SetValue("", fmt.Sprintf("Class %d", i))
+		server := clsid.Add("InprocServer32")
+		server.SetValue("", ExpandableString(`%SystemRoot%\system32\ole32.dll`))
+		server.SetValue("ThreadingModel", "Both")
+	}
+	uninstall := machine.Add(`Software\Microsoft\Windows\CurrentVersion\Uninstall`)
+	for i := range 200 {
+		k := uninstall.Add(fmt.Sprintf("Program %d", i))
+		k.SetValue("DisplayName", fmt.Sprintf("Program %d", i))
+		k.SetValue("DisplayVersion", "1.0.0")
+		k.SetValue("EstimatedSize", uint32(i))
+	}
+	user := &RegistryKey{Name: "HKEY_CURRENT_USER"}
+	user.Add(`Software\Wine\DllOverrides`).SetValue("d3d11", "native,builtin")
+
+	for name, k := range map[string]*RegistryKey{"system.reg": machine, "user.reg": user} {
+		name = filepath.Join(pfx.dir, name)
+		tmp, err := writeRegistryFile(name, k, ExportOptions{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := os.Rename(tmp, name); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return pfx
+}
+
+func BenchmarkParseRegistryFile(b *testing.B) {
+	name := filepath.Join(benchmarkPrefix(b).dir, "system.reg")
+	b.ResetTimer()
+	for range b.N {
+		if _, err := ParseRegistryFile(name); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPrefixRegistry(b *testing.B) {
+	pfx := benchmarkPrefix(b)
+	if _, err := pfx.Registry(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for range b.N {
+		if _, err := pfx.Registry(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRegistryQuery(b *testing.B) {
+	reg, err := benchmarkPrefix(b).Registry()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		path := fmt.Sprintf(`HKCR\CLSID\{%08x-0000-0000-c000-000000000046}\InprocServer32`, i%12000)
+		if reg.Query(path) == nil {
+			b.Fatalf("missing %s", path)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package wine
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// RegistryTx collects changes to the registry of a Wineprefix across any
+// number of keys, to be written together by [RegistryTx.Commit]. Unlike
+// [Prefix.RegistryAdd] and [Prefix.RegistryDelete], which run Wine for
+// every change, the changes are imported with a single run of regedit.
+type RegistryTx struct {
+	pfx     *Prefix
+	changes RegistryDiff
+	failed  []RegistryTxFailure
+}
+
+// RegistryTxOptions specify how a [RegistryTx] is committed.
+type RegistryTxOptions struct {
+	// Verify queries the changed keys once the changes were written,
+	// reporting the changes that did not take effect as failed. The
+	// keys are exported once for each root key, starting from the
+	// deepest key that the changes under that root share.
+	// Changes overwritten by later changes in the transaction are
+	// not verified.
+	Verify bool
+}
+
+// RegistryTxFailure is a change of a [RegistryTx] that failed.
+type RegistryTxFailure struct {
+	Change RegistryChange
+	Err    error
+}
+
+// RegistryTxError is returned by [RegistryTx.Commit] when any of the
+// changes failed.
+type RegistryTxError struct {
+	Failures []RegistryTxFailure
+}
+
+func (e *RegistryTxError) Error() string {
+	if len(e.Failures) == 1 {
+		f := e.Failures[0]
+		path := f.Change.Path
+		if f.Change.Kind != KeyRemoved {
+			path += `\` + cmp.Or(f.Change.Name, "(Default)")
+		}
+		return fmt.Sprintf("wine: %s: %s: %v", f.Change.Kind, path, f.Err)
+	}
+	return fmt.Sprintf("wine: %d registry changes failed", len(e.Failures))
+}
+
+// ErrChangeNotApplied is the error of changes that were committed, but
+// were not found to have taken effect when verifying them.
+var ErrChangeNotApplied = errors.New("change not applied")
+
+// RegistryTx returns an empty registry transaction for the Wineprefix.
+func (p *Prefix) RegistryTx() *RegistryTx {
+	return &RegistryTx{pfx: p}
+}
+
+// Set adds the setting of the named value of the registry key at path
+// to data to tx, creating the key if it does not exist. An empty name
+// sets the (Default) value.
+func (tx *RegistryTx) Set(path, name string, data RegistryData) *RegistryTx {
+	c := RegistryChange{Kind: ValueAdded, Path: path, Name: name, New: data}
+	if data == nil {
+		return tx.fail(c, errors.New("no registry data given"))
+	}
+	if err := (RegistryValue{name, data}).export(io.Discard, false); err != nil {
+		return tx.fail(c, err)
+	}
+	return tx.add(c)
+}
+
+// Delete adds the deletion of the named value of the registry key at
+// path to tx, or the key itself and its subkeys if name is empty.
+func (tx *RegistryTx) Delete(path, name string) *RegistryTx {
+	if name == "" {
+		return tx.add(RegistryChange{Kind: KeyRemoved, Path: path})
+	}
+	return tx.add(RegistryChange{Kind: ValueRemoved, Path: path, Name: name})
+}
+
+func (tx *RegistryTx) add(c RegistryChange) *RegistryTx {
+	root, rel, _ := strings.Cut(c.Path, `\`)
+	if !isRootName(root) {
+		return tx.fail(c, fmt.Errorf("invalid root key: %s", root))
+	}
+	if c.Kind == KeyRemoved && rel == "" {
+		return tx.fail(c, errors.New("cannot delete root key"))
+	}
+	c.Path = joinPath(rootName(root), rel)
+	tx.changes = append(tx.changes, c)
+	return tx
+}
+
+func (tx *RegistryTx) fail(c RegistryChange, err error) *RegistryTx {
+	tx.failed = append(tx.failed, RegistryTxFailure{c, err})
+	return tx
+}
+
+// Commit writes the changes of tx to the Wineprefix's registry.
+// See [RegistryTx.CommitWith] for more information.
+func (tx *RegistryTx) Commit() error {
+	return tx.CommitWith(RegistryTxOptions{})
+}
+
+// CommitWith is like [RegistryTx.Commit], but with the given options.
+//
+// If any of the changes are invalid, such as those with an unknown root
+// key, no changes are written and a [*RegistryTxError] with the invalid
+// changes is returned. Once written, the changes that were not applied
+// are returned in a RegistryTxError if the Verify option is set.
+func (tx *RegistryTx) CommitWith(opts RegistryTxOptions) error {
+	if len(tx.failed) > 0 {
+		return &RegistryTxError{tx.failed}
+	}
+	if len(tx.changes) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := tx.changes.Export(&buf); err != nil {
+		return err
+	}
+	if err := tx.pfx.registryImport(buf.Bytes()); err != nil {
+		return err
+	}
+	if !opts.Verify {
+		return nil
+	}
+
+	// Every query runs regedit, so the changes are verified against a
+	// single export of each root's keys that they have in common.
+	var changes RegistryDiff
+	ancestors := make(map[string]string)
+	for i, c := range tx.changes {
+		if superseded(tx.changes, i) {
+			continue
+		}
+		changes = append(changes, c)
+		root, _, _ := strings.Cut(c.Path, `\`)
+		if path, ok := ancestors[root]; ok {
+			ancestors[root] = commonPath(path, c.Path)
+		} else {
+			ancestors[root] = c.Path
+		}
+	}
+	keys := make(map[string]*RegistryKey, len(ancestors))
+	for root, path := range ancestors {
+		k, err := tx.pfx.RegistryQuery(path)
+		if err != nil {
+			return fmt.Errorf("verify: %w", err)
+		}
+		keys[root] = k
+	}
+
+	var failed []RegistryTxFailure
+	for _, c := range changes {
+		root, _, _ := strings.Cut(c.Path, `\`)
+		k := keys[root]
+		if k != nil {
+			k = k.Query(relPath(c.Path, ancestors[root]))
+		}
+		if !applied(c, k) {
+			failed = append(failed, RegistryTxFailure{c, ErrChangeNotApplied})
+		}
+	}
+	if len(failed) > 0 {
+		return &RegistryTxError{failed}
+	}
+	return nil
+}
+
+// superseded reports whether the change at i in d is overwritten by a
+// later change, leaving only the later change to be verified. A key
+// deletion is superseded by later changes within the key, which create
+// it again.
+func superseded(d RegistryDiff, i int) bool {
+	c := d[i]
+	for _, later := range d[i+1:] {
+		switch {
+		case later.Kind == KeyRemoved:
+			if withinPath(c.Path, later.Path) {
+				return true
+			}
+		case c.Kind == KeyRemoved:
+			if withinPath(later.Path, c.Path) {
+				return true
+			}
+		case equalName(c.Path, later.Path) && equalName(c.Name, later.Name):
+			return true
+		}
+	}
+	return false
+}
+
+// withinPath reports whether the registry key path is parent or one of
+// its subkeys.
+func withinPath(path, parent string) bool {
+	return equalName(path, parent) ||
+		strings.HasPrefix(foldName(path), foldName(parent)+`\`)
+}
+
+// applied reports whether the change has taken effect in k, the key at
+// the change's path. The data set is compared by converting it to the
+// type of the value's data, as regedit may change its type, such as by
+// writing [DwordLE] data as REG_DWORD.
+func applied(c RegistryChange, k *RegistryKey) bool {
+	if c.Kind == KeyRemoved {
+		return k == nil
+	}
+	var v *RegistryValue
+	if k != nil {
+		v = k.GetValue(c.Name)
+	}
+	if _, ok := c.New.(Deleted); ok || c.Kind == ValueRemoved {
+		return v == nil
+	}
+	if v == nil {
+		return false
+	}
+	data, err := convertData(c.New, v.Data)
+	return err == nil && reflect.DeepEqual(data, v.Data)
+}
+
+// commonPath returns the deepest registry key path that both registry
+// key paths a and b are within.
+func commonPath(a, b string) string {
+	as, bs := strings.Split(a, `\`), strings.Split(b, `\`)
+	n := 0
+	for n < len(as) && n < len(bs) && equalName(as[n], bs[n]) {
+		n++
+	}
+	return strings.Join(as[:n], `\`)
+}
+
+// relPath returns the registry key path relative to parent, which path
+// is within.
+func relPath(path, parent string) string {
+	n := strings.Count(parent, `\`) + 1
+	return strings.Join(strings.Split(path, `\`)[n:], `\`)
+}
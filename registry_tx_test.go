@@ -0,0 +1,117 @@
+package wine
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestRegistryTx(t *testing.T) {
+	tx := New(t.TempDir(), "").RegistryTx().
+		Set(`HKCU\Software\Foo`, "Foo", "Bar").
+		Set(`HKCU\Software\Foo`, "", uint32(1)).
+		Delete(`HKLM\Software\Bar`, "Bar").
+		Delete(`HKLM\Software\Baz`, "")
+
+	buf := new(bytes.Buffer)
+	if err := tx.changes.Export(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != `Windows Registry Editor Version 5.00
+
+[HKEY_CURRENT_USER\Software\Foo]
+"Foo"="Bar"
+@=dword:00000001
+
+[HKEY_LOCAL_MACHINE\Software\Bar]
+"Bar"=-
+
+[-HKEY_LOCAL_MACHINE\Software\Baz]
+` {
+		t.Fatalf("unexpected patch %s", buf)
+	}
+
+	tx.Set(`HKFOO\Software`, "Foo", "Bar").
+		Set(`HKCU\Software`, "Foo", nil).
+		Set(`HKCU\Software`, "Foo", 1).
+		Delete(`HKCU`, "")
+	var txErr *RegistryTxError
+	if err := tx.Commit(); !errors.As(err, &txErr) || len(txErr.Failures) != 4 {
+		t.Fatalf("expected invalid changes, got %v", err)
+	}
+
+	k := &RegistryKey{Values: []RegistryValue{{"Foo", uint32(1)}, {"Bar", "Baz"}}}
+	for _, tt := range []struct {
+		c    RegistryChange
+		k    *RegistryKey
+		want bool
+	}{
+		{RegistryChange{Kind: ValueAdded, Name: "foo", New: DwordLE(1)}, k, true},
+		{RegistryChange{Kind: ValueAdded, Name: "Bar", New: BinaryString(encodeW("Quz\x00"))}, k, false},
+		{RegistryChange{Kind: ValueAdded, Name: "Bar", New: BinaryString(encodeW("Baz\x00"))}, k, true},
+		{RegistryChange{Kind: ValueAdded, Name: "Foo", New: uint32(2)}, k, false},
+		{RegistryChange{Kind: ValueAdded, Name: "Foo", New: Deleted{}}, k, false},
+		{RegistryChange{Kind: ValueAdded, Name: "Foo", New: uint32(1)}, nil, false},
+		{RegistryChange{Kind: ValueRemoved, Name: "Baz"}, k, true},
+		{RegistryChange{Kind: ValueRemoved, Name: "Baz"}, nil, true},
+		{RegistryChange{Kind: KeyRemoved}, k, false},
+	} {
+		if got := applied(tt.c, tt.k); got != tt.want {
+			t.Errorf("%+v: expected applied %t, got %t", tt.c, tt.want, got)
+		}
+	}
+
+	d := RegistryDiff{
+		{Kind: ValueAdded, Path: `HKEY_CURRENT_USER\Foo`, Name: "Foo", New: uint32(1)},
+		{Kind: KeyRemoved, Path: `HKEY_CURRENT_USER\Foo\Bar`},
+		{Kind: ValueAdded, Path: `HKEY_CURRENT_USER\Foo`, Name: "Bar", New: uint32(1)},
+		{Kind: ValueRemoved, Path: `HKEY_CURRENT_USER\FOO`, Name: "foo"},
+		{Kind: ValueAdded, Path: `HKEY_CURRENT_USER\Foo\Bar\Baz`, Name: "Baz", New: uint32(1)},
+		{Kind: KeyRemoved, Path: `HKEY_CURRENT_USER\Quz`},
+		{Kind: ValueAdded, Path: `HKEY_CURRENT_USER\Quz\Quux`, Name: "Quux", New: uint32(1)},
+		{Kind: KeyRemoved, Path: `HKEY_CURRENT_USER\Quz`},
+		{Kind: KeyRemoved, Path: `HKEY_CURRENT_USER\Quz\Quux`},
+	}
+	for i, want := range []bool{true, true, false, false, false, true, true, false, false} {
+		if got := superseded(d, i); got != want {
+			t.Errorf("%+v: expected superseded %t, got %t", d[i], want, got)
+		}
+	}
+
+	for _, tt := range []struct{ a, b, want string }{
+		{`HKEY_CURRENT_USER\Foo\Bar`, `HKEY_CURRENT_USER\foo\Baz`, `HKEY_CURRENT_USER\Foo`},
+		{`HKEY_CURRENT_USER\Foo`, `HKEY_CURRENT_USER\Foo\Bar`, `HKEY_CURRENT_USER\Foo`},
+		{`HKEY_CURRENT_USER\Foo`, `HKEY_CURRENT_USER\Foobar`, `HKEY_CURRENT_USER`},
+	} {
+		got := commonPath(tt.a, tt.b)
+		if got != tt.want {
+			t.Errorf("%s, %s: expected common path %s, got %s", tt.a, tt.b, tt.want, got)
+		}
+		if rel := relPath(tt.b, got); !equalName(joinPath(got, rel), tt.b) {
+			t.Errorf("%s: unexpected path %s relative to %s", tt.b, rel, got)
+		}
+	}
+}
+
+func TestPrefixRegistryTx(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+	path := `HKCU\Software\Foobar`
+
+	err := testPfx.RegistryTx().
+		Set(path, "Foo", uint32(0xdeadbeef)).
+		Set(path+`\Bar`, "Baz", []string{"Foo", "Bar"}).
+		CommitWith(RegistryTxOptions{Verify: true})
+	if err != nil {
+		t.Fatalf("unexpected commit error: %v", err)
+	}
+
+	err = testPfx.RegistryTx().
+		Delete(path, "Foo").
+		Delete(path, "").
+		CommitWith(RegistryTxOptions{Verify: true})
+	if err != nil {
+		t.Fatalf("unexpected commit error: %v", err)
+	}
+}
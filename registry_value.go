@@ -0,0 +1,271 @@
+package wine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ErrValueNotFound is returned by the typed value accessors of
+// [RegistryKey] if the named value does not exist.
+var ErrValueNotFound = errors.New("wine: registry value not found")
+
+// RegistryDataType is the set of Go types that [RegistryData] may hold,
+// and that values can be retrieved as with [ValueAs].
+type RegistryDataType interface {
+	string | ExpandableString | Link | BinaryString |
+		uint32 | DwordLE | DwordBE | uint64 |
+		[]string | []byte | InternalBytes
+}
+
+// ValueAs retrieves the named value's data in k as the type T, converting
+// it from compatible types if necessary:
+//   - string, [ExpandableString], [Link] and [BinaryString] convert
+//     between each other, with BinaryString being NUL-terminated
+//     UTF16LE text.
+//   - uint32, [DwordLE], [DwordBE] and uint64 convert between each
+//     other, as long as the data fits within T.
+//   - []string can be converted from a single string.
+//   - []byte and [BinaryString] convert between each other, and can
+//     be converted from the data of [InternalBytes].
+//
+// If the value does not exist, an error wrapping ErrValueNotFound is
+// returned. Incompatible data will return an error describing the
+// value and its type.
+func ValueAs[T RegistryDataType](k *RegistryKey, name string) (T, error) {
+	var t T
+	v := k.GetValue(name)
+	if v == nil {
+		return t, fmt.Errorf("%w: %s", ErrValueNotFound, valuePath(k, name))
+	}
+
+	data, err := convertData(v.Data, t)
+	if err != nil {
+		return t, fmt.Errorf("wine: %s: %w", valuePath(k, name), err)
+	}
+	return data.(T), nil
+}
+
+// String returns the named value's data in k as a string.
+// See [ValueAs] for more information.
+func (k *RegistryKey) String(name string) (string, error) {
+	return ValueAs[string](k, name)
+}
+
+// Uint32 returns the named value's data in k as a uint32.
+// See [ValueAs] for more information.
+func (k *RegistryKey) Uint32(name string) (uint32, error) {
+	return ValueAs[uint32](k, name)
+}
+
+// Uint64 returns the named value's data in k as a uint64.
+// See [ValueAs] for more information.
+func (k *RegistryKey) Uint64(name string) (uint64, error) {
+	return ValueAs[uint64](k, name)
+}
+
+// Strings returns the named value's data in k as a []string.
+// See [ValueAs] for more information.
+func (k *RegistryKey) Strings(name string) ([]string, error) {
+	return ValueAs[[]string](k, name)
+}
+
+// Bytes returns the named value's data in k as a []byte.
+// See [ValueAs] for more information.
+func (k *RegistryKey) Bytes(name string) ([]byte, error) {
+	return ValueAs[[]byte](k, name)
+}
+
+func valuePath(k *RegistryKey, name string) string {
+	if name == "" {
+		name = "(Default)"
+	}
+	return k.Path() + `\` + name
+}
+
+// convertData converts data to the type of to.
+func convertData(data RegistryData, to any) (RegistryData, error) {
+	var (
+		text    string
+		number  uint64
+		bytes   []byte
+		isText  bool
+		isNum   bool
+		isBytes bool
+	)
+	switch d := data.(type) {
+	case string:
+		text, isText = d, true
+	case ExpandableString:
+		text, isText = string(d), true
+	case Link:
+		text, isText = string(d), true
+	case BinaryString:
+		bytes, isBytes = d, true
+		if len(d) >= 2 {
+			s, err := decodeW(d)
+			text, isText = s, err == nil
+		}
+	case uint32:
+		number, isNum = uint64(d), true
+	case DwordLE:
+		number, isNum = uint64(d), true
+	case DwordBE:
+		number, isNum = uint64(d), true
+	case uint64:
+		number, isNum = d, true
+	case []byte:
+		bytes, isBytes = d, true
+	case InternalBytes:
+		bytes, isBytes = d.Data, true
+	}
+
+	fits := isNum && number <= math.MaxUint32
+	switch to.(type) {
+	case string:
+		if isText {
+			return text, nil
+		}
+	case ExpandableString:
+		if isText {
+			return ExpandableString(text), nil
+		}
+	case Link:
+		if isText {
+			return Link(text), nil
+		}
+	case uint32:
+		if fits {
+			return uint32(number), nil
+		}
+	case DwordLE:
+		if fits {
+			return DwordLE(number), nil
+		}
+	case DwordBE:
+		if fits {
+			return DwordBE(number), nil
+		}
+	case uint64:
+		if isNum {
+			return number, nil
+		}
+	case []string:
+		if d, ok := data.([]string); ok {
+			return d, nil
+		}
+		if isText {
+			return []string{text}, nil
+		}
+	case []byte:
+		if isBytes {
+			return bytes, nil
+		}
+	case BinaryString:
+		if isBytes {
+			return BinaryString(bytes), nil
+		}
+		if isText {
+			return BinaryString(encodeW(text + "\x00")), nil
+		}
+	case InternalBytes:
+		if d, ok := data.(InternalBytes); ok {
+			return d, nil
+		}
+	}
+
+	if isNum && !fits {
+		return nil, fmt.Errorf("%T data %d overflows %T", data, number, to)
+	}
+	return nil, fmt.Errorf("%T data is not convertible to %T", data, to)
+}
+
+// Expand replaces the %NAME% environment variable references in s, such
+// as those of [ExpandableString] data, with the values of the Wineprefix's
+// environment variables stored in r. References to unknown variables are
+// left as is, and names are matched case-insensitively.
+//
+// The environment is retrieved from HKEY_CURRENT_USER\Environment and
+// HKEY_LOCAL_MACHINE's Session Manager\Environment, with the user's
+// variables taking precedence. SystemRoot, SystemDrive, ProgramFiles and
+// USERPROFILE are retrieved from where Wine stores them.
+func (r *Registry) Expand(s string) string {
+	return expand(s, r.environment(), 0)
+}
+
+// maxExpandDepth is the maximum depth of environment variables referencing
+// other environment variables to be expanded.
+const maxExpandDepth = 8
+
+func expand(s string, env map[string]string, depth int) string {
+	var sb strings.Builder
+	for {
+		start := strings.IndexByte(s, '%')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start+1:], '%')
+		if end < 0 {
+			break
+		}
+		end += start + 1
+
+		v, ok := env[strings.ToUpper(s[start+1:end])]
+		if !ok {
+			// The closing % may be the start of a known variable
+			sb.WriteString(s[:end])
+			s = s[end:]
+			continue
+		}
+		if depth < maxExpandDepth {
+			v = expand(v, env, depth+1)
+		}
+		sb.WriteString(s[:start])
+		sb.WriteString(v)
+		s = s[end+1:]
+	}
+	sb.WriteString(s)
+	return sb.String()
+}
+
+// environment returns the Wineprefix's environment variables, with
+// their names upper-cased.
+func (r *Registry) environment() map[string]string {
+	env := make(map[string]string)
+	set := func(path, value, name string) {
+		k := r.QueryFollow(path)
+		if k == nil {
+			return
+		}
+		if s, err := k.String(value); err == nil {
+			env[name] = s
+		}
+	}
+
+	set(`HKLM\Software\Microsoft\Windows NT\CurrentVersion`, "SystemRoot", "SYSTEMROOT")
+	if root := env["SYSTEMROOT"]; len(root) >= 2 && root[1] == ':' {
+		env["SYSTEMDRIVE"] = root[:2]
+	}
+	set(`HKLM\Software\Microsoft\Windows\CurrentVersion`, "ProgramFilesDir", "PROGRAMFILES")
+	set(`HKLM\Software\Microsoft\Windows NT\CurrentVersion\ProfileList\`+sid,
+		"ProfileImagePath", "USERPROFILE")
+
+	for _, path := range []string{
+		`HKLM\System\CurrentControlSet\Control\Session Manager\Environment`,
+		`HKCU\Environment`,
+	} {
+		k := r.QueryFollow(path)
+		if k == nil {
+			continue
+		}
+		for _, v := range k.Values {
+			s, err := k.String(v.Name)
+			if err != nil {
+				continue
+			}
+			env[strings.ToUpper(v.Name)] = s
+		}
+	}
+	return env
+}
@@ -0,0 +1,76 @@
+package wine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRegistryValueAs(t *testing.T) {
+	k := testdata().Query(`Foo\Bar`)
+	k.SetValue("Value N", "C:\\Foo")
+
+	if _, err := k.String("Value Z"); !errors.Is(err, ErrValueNotFound) {
+		t.Errorf("expected missing value error, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		get  func() (any, error)
+		want any
+	}{
+		{"Value F", func() (any, error) { return k.Uint64("Value F") }, uint64(0xdeadbeef)},
+		{"Value F", func() (any, error) { return k.Uint32("Value F") }, uint32(0xdeadbeef)},
+		{"Value G", func() (any, error) { return k.Strings("Value G") }, []string{`C:\Foo`, `C:\Bar`}},
+		{"Value H", func() (any, error) { return k.String("Value H") }, `%APPDATA%\Foo`},
+		{"Value I", func() (any, error) { return k.String("Value I") }, "Hi"},
+		{"Value I", func() (any, error) { return k.Bytes("Value I") }, []byte{0x48, 0x0, 0x69, 0x0, 0x0, 0x0}},
+		{"Value N", func() (any, error) { return k.Strings("Value N") }, []string{`C:\Foo`}},
+		{"Value N", func() (any, error) { return ValueAs[ExpandableString](k, "value n") }, ExpandableString(`C:\Foo`)},
+		{"Value N", func() (any, error) { return ValueAs[BinaryString](k, "Value N") }, BinaryString(encodeW("C:\\Foo\x00"))},
+		{"Value J", func() (any, error) { return ValueAs[DwordLE](k.Query("Baz"), "Value J") }, DwordLE(0x12345678)},
+		{"Value K", func() (any, error) { return k.Query("Baz").Uint32("Value K") }, uint32(0x12345678)},
+		{"Value M", func() (any, error) { return k.Query("Baz").Bytes("Value M") }, []byte{0xde}},
+	} {
+		got, err := tt.get()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %#v, got %#v", tt.name, tt.want, got)
+		}
+	}
+
+	if _, err := k.Uint32("Value G"); err == nil {
+		t.Error("expected conversion error")
+	}
+
+	k.SetValue("Value O", uint64(1<<32))
+	if _, err := k.Uint32("Value O"); err == nil {
+		t.Error("expected overflow error")
+	}
+}
+
+func TestRegistryExpand(t *testing.T) {
+	var reg Registry
+	reg.queryPath(`HKLM\Software\Microsoft\Windows NT\CurrentVersion`, true).
+		SetValue("SystemRoot", `C:\windows`)
+	reg.queryPath(`HKLM\System\ControlSet001\Control\Session Manager\Environment`, true).
+		SetValue("windir", ExpandableString(`%SystemRoot%`))
+	reg.queryPath(`HKLM\System\CurrentControlSet`, true).
+		SetLink(`\REGISTRY\Machine\System\ControlSet001`)
+	reg.queryPath(`HKCU\Environment`, true).
+		SetValue("TEMP", ExpandableString(`%SystemDrive%\temp`))
+
+	for in, want := range map[string]string{
+		`%SystemRoot%\system32`: `C:\windows\system32`,
+		`%WINDIR%\%temp%`:       `C:\windows\C:\temp`,
+		`100%%Foo%%windir%`:     `100%%Foo%C:\windows`,
+		`%Unknown%`:             `%Unknown%`,
+	} {
+		if got := reg.Expand(in); got != want {
+			t.Errorf("expected %s to expand to %s, got %s", in, want, got)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package wine
+
+import (
+	"errors"
+	"iter"
+	"strings"
+)
+
+var (
+	// SkipSubkeys is used as a return value from the function passed
+	// to [RegistryKey.Walk] to skip walking the subkeys of the key.
+	SkipSubkeys = errors.New("skip subkeys")
+
+	// SkipAll is used as a return value from the function passed to
+	// [RegistryKey.Walk] to stop walking entirely.
+	SkipAll = errors.New("skip everything")
+)
+
+// Walk walks the tree of k, calling fn for k and each of its subkeys in
+// depth-first order, with each key's subkeys walked in the order they
+// are in.
+//
+// If fn returns [SkipSubkeys], the subkeys of the key passed to fn are
+// skipped; if it returns [SkipAll], walking stops and nil is returned.
+// Any other error stops walking and is returned by Walk.
+//
+// fn may add or delete subkeys of the key passed to it, but must not add
+// or delete the key itself or its siblings.
+func (k *RegistryKey) Walk(fn func(k *RegistryKey) error) error {
+	err := k.walk(fn)
+	if err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (k *RegistryKey) walk(fn func(k *RegistryKey) error) error {
+	if err := fn(k); err != nil {
+		if err == SkipSubkeys {
+			return nil
+		}
+		return err
+	}
+	for _, subkey := range k.Subkeys {
+		if err := subkey.walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// All returns an iterator over k and all of its subkeys, in the order
+// they are walked by [RegistryKey.Walk].
+func (k *RegistryKey) All() iter.Seq[*RegistryKey] {
+	return func(yield func(*RegistryKey) bool) {
+		_ = k.Walk(func(k *RegistryKey) error {
+			if !yield(k) {
+				return SkipAll
+			}
+			return nil
+		})
+	}
+}
+
+// AllValues returns an iterator over the values of k and all of its
+// subkeys, paired with the absolute path of their key. It is not named
+// Values as to not conflict with the Values field.
+func (k *RegistryKey) AllValues() iter.Seq2[string, RegistryValue] {
+	return func(yield func(string, RegistryValue) bool) {
+		for k := range k.All() {
+			path := k.Path()
+			for _, v := range k.Values {
+				if !yield(path, v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Find returns an iterator over the keys in k's tree whose path relative
+// to k matches the pattern. Each of the backslash-separated elements of
+// pattern are matched against the key names case-insensitively, where
+// '*' matches any sequence of characters and '?' matches any single
+// character. For example, 'Software\*\Uninstall\*' matches the
+// uninstallation keys of every vendor.
+//
+// An empty pattern matches k itself.
+func (k *RegistryKey) Find(pattern string) iter.Seq[*RegistryKey] {
+	return func(yield func(*RegistryKey) bool) {
+		if pattern == "" {
+			yield(k)
+			return
+		}
+		k.find(strings.Split(pattern, `\`), yield)
+	}
+}
+
+// find yields the subkeys of k matching the pattern elements, and reports
+// whether yield requested to continue.
+func (k *RegistryKey) find(elems []string, yield func(*RegistryKey) bool) bool {
+	if len(elems) == 0 {
+		return yield(k)
+	}
+
+	elem := elems[0]
+	if !strings.ContainsAny(elem, "*?") {
+		if subkey := k.subkey(elem); subkey != nil {
+			return subkey.find(elems[1:], yield)
+		}
+		return true
+	}
+	for _, subkey := range k.Subkeys {
+		if matchName(elem, subkey.Name) && !subkey.find(elems[1:], yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// FindValues returns an iterator over the values matching valuePattern
+// within the keys matched by keyPattern as done by [RegistryKey.Find],
+// paired with the absolute path of their key. The (Default) value is
+// only matched by an empty valuePattern or '*'.
+func (k *RegistryKey) FindValues(keyPattern, valuePattern string) iter.Seq2[string, RegistryValue] {
+	return func(yield func(string, RegistryValue) bool) {
+		for k := range k.Find(keyPattern) {
+			path := k.Path()
+			for _, v := range k.Values {
+				if !matchName(valuePattern, v.Name) {
+					continue
+				}
+				if !yield(path, v) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Find is like [RegistryKey.Find], with the pattern beginning with the
+// root key as accepted by [Registry.Query], which cannot be a pattern.
+func (r *Registry) Find(pattern string) iter.Seq[*RegistryKey] {
+	k, rel := r.root(pattern, false)
+	if k == nil {
+		return func(func(*RegistryKey) bool) {}
+	}
+	return k.Find(rel)
+}
+
+// FindValues is like [RegistryKey.FindValues], with the key pattern
+// beginning with the root key as done by [Registry.Find].
+func (r *Registry) FindValues(keyPattern, valuePattern string) iter.Seq2[string, RegistryValue] {
+	k, rel := r.root(keyPattern, false)
+	if k == nil {
+		return func(func(string, RegistryValue) bool) {}
+	}
+	return k.FindValues(rel, valuePattern)
+}
+
+// matchName reports whether the name matches the pattern, where '*'
+// matches any sequence of characters and '?' matches any single
+// character, compared case-insensitively as done by equalName.
+func matchName(pattern, name string) bool {
+	p, n := []rune(foldName(pattern)), []rune(foldName(name))
+	i, j := 0, 0
+	// Positions to backtrack to after the last '*' failed to match
+	star, next := -1, 0
+	for j < len(n) {
+		switch {
+		case i < len(p) && p[i] == '*':
+			star, next = i, j
+			i++
+		case i < len(p) && (p[i] == '?' || p[i] == n[j]):
+			i++
+			j++
+		case star >= 0:
+			next++
+			i, j = star+1, next
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
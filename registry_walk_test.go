@@ -0,0 +1,122 @@
+package wine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRegistryWalk(t *testing.T) {
+	root := testdata()
+
+	var paths []string
+	err := root.Walk(func(k *RegistryKey) error {
+		paths = append(paths, k.Path())
+		if k.Name == "Bar" {
+			return SkipSubkeys
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(paths, []string{
+		`HKEY_CURRENT_USER`,
+		`HKEY_CURRENT_USER\Foo`,
+		`HKEY_CURRENT_USER\Foo\Bar`,
+		`HKEY_CURRENT_USER\Foo\Quz`,
+		`HKEY_CURRENT_USER\Foo\Baz`,
+	}) {
+		t.Fatalf("unexpected walk %v", paths)
+	}
+
+	errStop := errors.New("stop")
+	if err := root.Walk(func(k *RegistryKey) error {
+		if k.Name == "Foo" {
+			return errStop
+		}
+		return nil
+	}); err != errStop {
+		t.Fatalf("expected walk error, got %v", err)
+	}
+
+	n := 0
+	for range root.All() {
+		if n++; n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Fatalf("expected iteration to stop, got %d keys", n)
+	}
+
+	var names []string
+	for path, v := range root.AllValues() {
+		if path == `HKEY_CURRENT_USER\Foo\Bar\Baz` {
+			names = append(names, v.Name)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"Value J", "Value K", "Value L", "Value M"}) {
+		t.Fatalf("unexpected values %v", names)
+	}
+}
+
+func TestRegistryFind(t *testing.T) {
+	root := testdata()
+
+	for pattern, want := range map[string][]string{
+		"":         {`HKEY_CURRENT_USER`},
+		`foo\*`:    {`HKEY_CURRENT_USER\Foo\Bar`, `HKEY_CURRENT_USER\Foo\Quz`, `HKEY_CURRENT_USER\Foo\Baz`},
+		`*\ba?`:    {`HKEY_CURRENT_USER\Foo\Bar`, `HKEY_CURRENT_USER\Foo\Baz`},
+		`*\*\B*z`:  {`HKEY_CURRENT_USER\Foo\Bar\Baz`},
+		`Foo\Quux`: nil,
+	} {
+		var paths []string
+		for k := range root.Find(pattern) {
+			paths = append(paths, k.Path())
+		}
+		if !reflect.DeepEqual(paths, want) {
+			t.Errorf("%q: expected %v, got %v", pattern, want, paths)
+		}
+	}
+
+	var names []string
+	for _, v := range root.FindValues(`Foo\*`, "value [?]") {
+		names = append(names, v.Name)
+	}
+	for _, v := range root.FindValues(`Foo\Bar`, "value ?") {
+		names = append(names, v.Name)
+	}
+	for _, v := range root.FindValues("", "") {
+		names = append(names, v.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"Value F", "Value G", "Value H", "Value I", ""}) {
+		t.Fatalf("unexpected values %v", names)
+	}
+
+	t.Run("registry", func(t *testing.T) {
+		var reg Registry
+		reg.queryPath(`HKLM\Software\Foo\Uninstall\Foo`, true)
+		reg.queryPath(`HKLM\Software\Bar\Uninstall\Bar`, true).SetValue("DisplayName", "Bar")
+		reg.queryPath(`HKLM\Software\Baz\Settings`, true)
+
+		var paths []string
+		for k := range reg.Find(`HKLM\Software\*\Uninstall\*`) {
+			paths = append(paths, k.Path())
+		}
+		if !reflect.DeepEqual(paths, []string{
+			`HKEY_LOCAL_MACHINE\Software\Foo\Uninstall\Foo`,
+			`HKEY_LOCAL_MACHINE\Software\Bar\Uninstall\Bar`,
+		}) {
+			t.Fatalf("unexpected keys %v", paths)
+		}
+		for path, v := range reg.FindValues(`HKLM\Software\*\Uninstall\*`, "display*") {
+			if path != `HKEY_LOCAL_MACHINE\Software\Bar\Uninstall\Bar` || v.Data != "Bar" {
+				t.Fatalf("unexpected value %s %v", path, v)
+			}
+		}
+		for range reg.Find(`HKCU\Software`) {
+			t.Fatal("unexpected key in missing root key")
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package wine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultPollInterval is the interval at which [Prefix.WatchRegistry]
+// checks the Wineprefix's registry files for changes.
+const defaultPollInterval = time.Second
+
+// RegistryWatchOptions specify how [Prefix.WatchRegistryWith] watches
+// the Wineprefix's registry files.
+type RegistryWatchOptions struct {
+	// Interval is the interval at which the registry files are checked
+	// for changes, defaulting to a second if zero.
+	Interval time.Duration
+}
+
+// registryFiles are the Wineprefix's registry files, as parsed by
+// [Prefix.Registry].
+var registryFiles = []string{"system.reg", "user.reg", "userdef.reg"}
+
+// RegistryEvent represents a change of one of the Wineprefix's
+// registry files.
+type RegistryEvent struct {
+	// File is the name of the changed registry file, such as "user.reg".
+	File string
+
+	// Changes are the changes made to the registry file's root key
+	// since it was last read, with absolute paths.
+	Changes RegistryDiff
+
+	// Err is the error encountered reading the registry file, in which
+	// case Changes will be empty and the file will be read again once
+	// it changes.
+	Err error
+}
+
+// WatchRegistry watches the Wineprefix's registry files for rewrites, such
+// as those done by the Wineserver when saving its registry, and sends their
+// changes on the returned channel. The files are polled for replacement and
+// changes in their modification time and size every second.
+//
+// The files are first read when WatchRegistry is called, and changes made
+// prior to that are not reported. Missing registry files are treated as
+// empty, and creating or removing one reports the addition or removal of
+// its root key.
+//
+// The channel is closed once ctx is done.
+func (p *Prefix) WatchRegistry(ctx context.Context) <-chan RegistryEvent {
+	return p.WatchRegistryWith(ctx, RegistryWatchOptions{})
+}
+
+// WatchRegistryWith is like [Prefix.WatchRegistry], but with the given
+// options.
+func (p *Prefix) WatchRegistryWith(ctx context.Context, opts RegistryWatchOptions) <-chan RegistryEvent {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	files := make([]*registryFile, len(registryFiles))
+	var initial []RegistryEvent
+	for i, name := range registryFiles {
+		files[i] = &registryFile{name: filepath.Join(p.dir, name)}
+		if _, err := files[i].update(); err != nil {
+			initial = append(initial, RegistryEvent{File: name, Err: err})
+		}
+	}
+
+	ch := make(chan RegistryEvent)
+	go func() {
+		defer close(ch)
+
+		send := func(e RegistryEvent) bool {
+			select {
+			case ch <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for _, e := range initial {
+			if !send(e) {
+				return
+			}
+		}
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+
+			for _, f := range files {
+				d, err := f.update()
+				if err == nil && len(d) == 0 {
+					continue
+				}
+				if !send(RegistryEvent{File: filepath.Base(f.name), Changes: d, Err: err}) {
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// update re-reads f if it changed since it was last read, and returns
+// the changes made to it.
+func (f *registryFile) update() (RegistryDiff, error) {
+	fi, err := os.Stat(f.name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if (fi == nil && f.info == nil) || f.unchanged(fi) {
+		return nil, nil
+	}
+
+	var k *RegistryKey
+	if fi != nil {
+		k, err = ParseRegistryFile(f.name)
+		if err != nil {
+			f.info = fi
+			return nil, err
+		}
+	}
+
+	d := Diff(f.key, k)
+	f.info, f.key = fi, k
+	return d, nil
+}
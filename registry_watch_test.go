@@ -0,0 +1,72 @@
+package wine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryWatch(t *testing.T) {
+	dir := t.TempDir()
+	pfx := New(dir, "")
+	user := filepath.Join(dir, "user.reg")
+
+	if err := os.WriteFile(filepath.Join(dir, "system.reg"), []byte(registrySystemData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(user, []byte(registryUserData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := pfx.WatchRegistryWith(ctx, RegistryWatchOptions{Interval: 10 * time.Millisecond})
+
+	next := func() RegistryEvent {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for registry event")
+		}
+		return RegistryEvent{}
+	}
+
+	// Rewrite the files the same way the Wineserver does, as to
+	// not read partially written files.
+	write := func(name, data string) {
+		if err := os.WriteFile(name+".tmp", []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(name+".tmp", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := strings.Replace(registryUserData, `"Foo"="Bar"`, `"Foo"="Baz"`, 1)
+	write(user, data)
+	e := next()
+	if e.Err != nil {
+		t.Fatalf("unexpected error: %v", e.Err)
+	}
+	if !reflect.DeepEqual(e, RegistryEvent{File: "user.reg", Changes: RegistryDiff{{
+		Kind: ValueChanged, Path: `HKEY_CURRENT_USER\Software\Foobar`,
+		Name: "Foo", Old: "Bar", New: "Baz",
+	}}}) {
+		t.Fatalf("unexpected event %#v", e)
+	}
+
+	write(filepath.Join(dir, "userdef.reg"), registryDefaultUserData)
+	if e := next(); e.File != "userdef.reg" || len(e.Changes) == 0 ||
+		e.Changes[0] != (RegistryChange{Kind: KeyAdded, Path: `HKEY_USERS\.Default`}) {
+		t.Fatalf("unexpected event %#v", e)
+	}
+
+	cancel()
+	for range events {
+	}
+}
@@ -1,8 +1,13 @@
 package wine
 
 import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"strings"
+	"unicode"
 	"unicode/utf16"
 	"unicode/utf8"
 )
@@ -192,6 +197,27 @@ func Escape(src string, quote bool, raw bool) string {
 	return sb.String()
 }
 
+// equalName reports whether the registry key or value names a and b are
+// equal under Windows' case-insensitive comparison, where each character
+// is upcased prior to comparing.
+func equalName(a, b string) bool {
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		if ra != rb && unicode.ToUpper(ra) != unicode.ToUpper(rb) {
+			return false
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return a == b
+}
+
+// foldName returns the name in the form compared by equalName,
+// usable as a map key.
+func foldName(name string) string {
+	return strings.ToUpper(name)
+}
+
 func isXDigit16(c uint16) bool {
 	return c < 128 && isXDigit(byte(c))
 }
@@ -210,3 +236,162 @@ func toHex(c byte) uint16 {
 		return uint16(c - 'A' + 10)
 	}
 }
+
+// cp1252 maps the bytes 0x80 to 0x9F of Windows-1252, the ANSI code page
+// REGEDIT4 files are most commonly written in, to their characters.
+// Undefined bytes map to the C1 control characters, as done by Windows.
+var cp1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '\u008D', 'Ž', '\u008F',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '\u009D', 'ž', 'Ÿ',
+}
+
+// decodeANSI decodes the Windows-1252 encoded b, removing the
+// NULL terminator if present.
+func decodeANSI(b []byte) string {
+	b = bytes.TrimSuffix(b, []byte{0})
+	var sb strings.Builder
+	sb.Grow(len(b))
+	for _, c := range b {
+		switch {
+		case c < 0x80:
+			sb.WriteByte(c)
+		case c < 0xA0:
+			sb.WriteRune(cp1252[c-0x80])
+		default:
+			sb.WriteRune(rune(c))
+		}
+	}
+	return sb.String()
+}
+
+// utf16Reader decodes UTF-16LE text read from r into UTF-8.
+type utf16Reader struct {
+	r   io.Reader
+	buf []byte // read but undecoded input
+	out []byte // decoded but unread output
+	err error
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.out) == 0 {
+		if u.err != nil {
+			if len(u.buf) > 0 { // odd trailing byte
+				u.buf = u.buf[:0]
+				u.out = utf8.AppendRune(u.out, utf8.RuneError)
+				break
+			}
+			return 0, u.err
+		}
+
+		var chunk [4096]byte
+		n, err := u.r.Read(chunk[:])
+		u.buf, u.err = append(u.buf, chunk[:n]...), err
+		u.decode()
+	}
+
+	n := copy(p, u.out)
+	u.out = u.out[n:]
+	return n, nil
+}
+
+// decode decodes the complete characters in u.buf into u.out.
+func (u *utf16Reader) decode() {
+	i := 0
+	for ; i+2 <= len(u.buf); i += 2 {
+		r := rune(binary.LittleEndian.Uint16(u.buf[i:]))
+		if utf16.IsSurrogate(r) && r < 0xDC00 {
+			if i+4 > len(u.buf) && u.err == nil {
+				break // low surrogate not yet read
+			}
+			if i+4 <= len(u.buf) {
+				low := rune(binary.LittleEndian.Uint16(u.buf[i+2:]))
+				if d := utf16.DecodeRune(r, low); d != utf8.RuneError {
+					u.out = utf8.AppendRune(u.out, d)
+					i += 2
+					continue
+				}
+			}
+		}
+		if utf16.IsSurrogate(r) {
+			r = utf8.RuneError
+		}
+		u.out = utf8.AppendRune(u.out, r)
+	}
+	u.buf = u.buf[:copy(u.buf, u.buf[i:])]
+}
+
+// textWriter writes the UTF-8 text written to it to w, optionally
+// encoded as UTF-16LE and with its line endings converted to CRLF.
+type textWriter struct {
+	w       io.Writer
+	utf16   bool
+	crlf    bool
+	partial []byte // incomplete UTF-8 sequence of the last write
+}
+
+func (t *textWriter) Write(p []byte) (int, error) {
+	b := append(t.partial, p...)
+	end := len(b)
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	t.partial = append([]byte(nil), b[end:]...)
+
+	if err := t.write(b[:end]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *textWriter) write(b []byte) error {
+	if t.crlf {
+		b = bytes.ReplaceAll(b, []byte("\n"), []byte("\r\n"))
+	}
+	if t.utf16 {
+		b = encodeW(string(b))
+	}
+	_, err := t.w.Write(b)
+	return err
+}
+
+// Close writes any incomplete UTF-8 sequence left over as is.
+func (t *textWriter) Close() error {
+	b := t.partial
+	t.partial = nil
+	if len(b) == 0 {
+		return nil
+	}
+	return t.write(b)
+}
+
+// compareName compares the registry key or value names as done by
+// the Wineserver, by their uppercased UTF-16 code units and then their
+// lengths.
+func compareName(a, b string) int {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := range min(len(ua), len(ub)) {
+		if c := cmp.Compare(upperW(ua[i]), upperW(ub[i])); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(ua), len(ub))
+}
+
+// upperW returns the uppercase of the UTF-16 code unit c, which is
+// unchanged if it is a surrogate or its uppercase is not in the BMP.
+func upperW(c uint16) uint16 {
+	if utf16.IsSurrogate(rune(c)) {
+		return c
+	}
+	if u := unicode.ToUpper(rune(c)); u <= 0xFFFF {
+		return uint16(u)
+	}
+	return c
+}
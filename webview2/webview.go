@@ -94,8 +94,9 @@ func InstallerPath(pfx *wine.Prefix, version, arch string) string {
 func Install(pfx *wine.Prefix, name string) error {
 	if !pfx.IsProton() {
 		path := `HKCU\Software\Wine\AppDefaults\msedgewebview2.exe`
-		if k, _ := pfx.RegistryQuery(path); k == nil {
-			if err := pfx.RegistryAdd(path, "Version", "win7"); err != nil {
+		reg := pfx.RegistryBackend()
+		if k, _ := reg.Query(path); k == nil {
+			if err := reg.Set(path, "Version", "win7"); err != nil {
 				return fmt.Errorf("version set: %w", err)
 			}
 		}
@@ -128,16 +129,39 @@ func Installed(pfx *wine.Prefix, version string) bool {
 }
 
 // Current returns the current installed WebView2 version in the given
-// Wineprefix, by invoking the registry file. If an error occured, an
-// empty string will be returned.
+// Wineprefix. If an error occured, an empty string will be returned.
 //
-// It is reccomended to store the registry from [Prefix.Registry] and perform the
-// check manually, see [VersionPath].
+// The version is read through the Wineprefix's [wine.RegistryBackend]. If
+// the Wineprefix is not running, its registry file is scanned for the
+// version without parsing all of it.
 func Current(pfx *wine.Prefix) string {
-	k, _ := pfx.RegistryQuery(VersionPath)
-	if k != nil {
-		if v := k.GetValue("DisplayVersion"); v != nil {
-			return v.Data.(string)
+	reg := pfx.RegistryBackend()
+	if f, ok := reg.(*wine.FileRegistry); ok {
+		return currentFile(f.Prefix)
+	}
+
+	k, _ := reg.Query(VersionPath)
+	if k == nil {
+		return ""
+	}
+	v, _ := k.String("DisplayVersion")
+	return v
+}
+
+// currentFile is like Current, but scans the Wineprefix's system.reg.
+func currentFile(pfx *wine.Prefix) string {
+	f, err := os.Open(filepath.Join(pfx.Dir(), "system.reg"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	s := wine.NewRegistryScanner(f, VersionPath)
+	for r := range s.Records() {
+		if r.Value != nil && wine.EqualRegistryPath(r.Path, VersionPath) &&
+			strings.EqualFold(r.Value.Name, "DisplayVersion") {
+			v, _ := r.Value.Data.(string)
+			return v
 		}
 	}
 	return ""